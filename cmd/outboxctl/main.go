@@ -0,0 +1,292 @@
+// Command outboxctl inspect and repair outbox collection.
+//
+// Configuration is loaded through config.Load, from CONFIG_URL or -config url and environment variables
+// such as COLLECTION_URL and PUBSUB_URL. Records are decoded with event config under "config" key.
+// Collection is opened with mongodocstore, e.g. mongo://db/outbox with MONGO_SERVER_URL, and topics with kafka.
+//
+//	outboxctl list [-topic t] [-age 5m] [-limit 100]
+//	outboxctl show <id>
+//	outboxctl retry <id>... | -topic t [-age 5m] [-limit 100]
+//	outboxctl delete <id>... | -topic t [-age 5m] [-limit 100] -yes
+//	outboxctl stats
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"sort"
+	"text/tabwriter"
+	"time"
+
+	"github.com/sahalazain/go-common/config"
+	"github.com/sahalazain/go-common/event"
+	_ "gocloud.dev/docstore/mongodocstore"
+	_ "gocloud.dev/pubsub/kafkapubsub"
+)
+
+const usage = `usage: outboxctl [-config url] <command> [flags] [args]
+
+commands:
+  list     list pending records by topic and age
+  show     show record and its decoded event message
+  retry    publish records again, by id or topic
+  delete   delete records, by id or topic
+  stats    print backlog statistics
+`
+
+var defaultConfig = map[string]interface{}{
+	"collection_url": "",
+	"pubsub_url":     "",
+	"kafka_broker":   "",
+	"kafka":          map[string]interface{}{},
+	"mark_sent":      false,
+	"config":         map[string]interface{}{},
+}
+
+func main() {
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer cancel()
+
+	if err := run(ctx, os.Args[1:], os.Stdout); err != nil {
+		fmt.Fprintln(os.Stderr, "outboxctl:", err)
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, args []string, out io.Writer) error {
+	fs := flag.NewFlagSet("outboxctl", flag.ContinueOnError)
+	fs.SetOutput(out)
+	fs.Usage = func() { fmt.Fprint(out, usage) }
+	configURL := fs.String("config", "", "config url, CONFIG_URL by default")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("missing command")
+	}
+
+	conf, err := config.Load(defaultConfig, *configURL)
+	if err != nil {
+		return err
+	}
+
+	cmd, args := fs.Arg(0), fs.Args()[1:]
+	switch cmd {
+	case "list":
+		return list(ctx, conf, args, out)
+	case "show":
+		return show(ctx, conf, args, out)
+	case "retry":
+		return retry(ctx, conf, args, out)
+	case "delete":
+		return remove(ctx, conf, args, out)
+	case "stats":
+		return stats(ctx, conf, out)
+	default:
+		fs.Usage()
+		return fmt.Errorf("unknown command %q", cmd)
+	}
+}
+
+// selection records selected by id arguments or pending filter flags
+type selection struct {
+	fs     *flag.FlagSet
+	filter event.PendingFilter
+}
+
+func newSelection(name string, out io.Writer) *selection {
+	s := &selection{fs: flag.NewFlagSet(name, flag.ContinueOnError)}
+	s.fs.SetOutput(out)
+	s.fs.StringVar(&s.filter.Topic, "topic", "", "only records of topic")
+	s.fs.DurationVar(&s.filter.MinAge, "age", 0, "only records older than age")
+	s.fs.IntVar(&s.filter.Limit, "limit", 100, "maximum number of records, 0 is unlimited")
+	return s
+}
+
+// records get records by id arguments, or pending records matching filter without arguments
+func (s *selection) records(ctx context.Context, ins *event.OutboxInspector, requireTopic bool) ([]*event.OutboxRecord, error) {
+	if s.fs.NArg() == 0 {
+		if requireTopic && s.filter.Topic == "" {
+			return nil, errors.New("missing record id or -topic")
+		}
+		return ins.Pending(ctx, s.filter)
+	}
+
+	records := make([]*event.OutboxRecord, 0, s.fs.NArg())
+	for _, id := range s.fs.Args() {
+		o, err := ins.Get(ctx, id)
+		if err != nil {
+			return nil, fmt.Errorf("record %s: %w", id, err)
+		}
+		records = append(records, o)
+	}
+	return records, nil
+}
+
+func list(ctx context.Context, conf config.Getter, args []string, out io.Writer) error {
+	s := newSelection("list", out)
+	if err := s.fs.Parse(args); err != nil {
+		return err
+	}
+
+	ins, err := event.NewOutboxInspector(ctx, conf)
+	if err != nil {
+		return err
+	}
+	defer ins.Close()
+
+	records, err := ins.Pending(ctx, s.filter)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tTOPIC\tKEY\tAGE\tSTATUS\tATTEMPTS\tLAST ERROR")
+	now := time.Now()
+	for _, o := range records {
+		status := o.Status
+		if status == "" {
+			status = "pending"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%d\t%s\n", o.ID, o.KafkaTopic, o.KafkaKey,
+			now.Sub(o.CreatedAt).Truncate(time.Second), status, o.Attempts, o.LastError)
+	}
+	return w.Flush()
+}
+
+func show(ctx context.Context, conf config.Getter, args []string, out io.Writer) error {
+	if len(args) != 1 {
+		return errors.New("usage: outboxctl show <id>")
+	}
+
+	ins, err := event.NewOutboxInspector(ctx, conf)
+	if err != nil {
+		return err
+	}
+	defer ins.Close()
+
+	o, err := ins.Get(ctx, args[0])
+	if err != nil {
+		return err
+	}
+
+	msg, err := ins.Decode(ctx, o)
+	if err != nil {
+		return fmt.Errorf("decode record %s: %w", o.ID, err)
+	}
+
+	// stored value is replaced by decoded message
+	rec := *o
+	rec.KafkaValue = ""
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+	return enc.Encode(map[string]interface{}{
+		"record":  rec,
+		"message": msg,
+	})
+}
+
+func retry(ctx context.Context, conf config.Getter, args []string, out io.Writer) error {
+	s := newSelection("retry", out)
+	if err := s.fs.Parse(args); err != nil {
+		return err
+	}
+
+	ins, err := event.NewOutboxInspector(ctx, conf)
+	if err != nil {
+		return err
+	}
+	defer ins.Close()
+
+	records, err := s.records(ctx, ins, true)
+	if err != nil {
+		return err
+	}
+
+	relay, err := event.NewOutboxRelay(ctx, conf)
+	if err != nil {
+		return err
+	}
+	defer relay.Close(ctx)
+
+	n, err := relay.Send(ctx, records)
+	fmt.Fprintf(out, "%d of %d records sent\n", n, len(records))
+	return err
+}
+
+func remove(ctx context.Context, conf config.Getter, args []string, out io.Writer) error {
+	s := newSelection("delete", out)
+	yes := s.fs.Bool("yes", false, "confirm deleting records selected by topic")
+	if err := s.fs.Parse(args); err != nil {
+		return err
+	}
+
+	if s.fs.NArg() == 0 && !*yes {
+		return errors.New("deleting by topic requires -yes")
+	}
+
+	ins, err := event.NewOutboxInspector(ctx, conf)
+	if err != nil {
+		return err
+	}
+	defer ins.Close()
+
+	records, err := s.records(ctx, ins, true)
+	if err != nil {
+		return err
+	}
+
+	if err := ins.Delete(ctx, records); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "%d records deleted\n", len(records))
+	return nil
+}
+
+func stats(ctx context.Context, conf config.Getter, out io.Writer) error {
+	ins, err := event.NewOutboxInspector(ctx, conf)
+	if err != nil {
+		return err
+	}
+	defer ins.Close()
+
+	rep, err := ins.Stats(ctx)
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	age := func(t time.Time) string {
+		if t.IsZero() {
+			return "-"
+		}
+		return now.Sub(t).Truncate(time.Second).String()
+	}
+
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "total\t%d\n", rep.Total)
+	fmt.Fprintf(w, "pending\t%d\n", rep.Pending)
+	fmt.Fprintf(w, "failed\t%d\n", rep.Failed)
+	fmt.Fprintf(w, "sent\t%d\n", rep.Sent)
+	fmt.Fprintf(w, "bytes\t%d\n", rep.Bytes)
+	fmt.Fprintf(w, "oldest pending\t%s\n", age(rep.OldestPending))
+	fmt.Fprintf(w, "oldest sent\t%s\n", age(rep.OldestSent))
+
+	topics := make([]string, 0, len(rep.Topics))
+	for t := range rep.Topics {
+		topics = append(topics, t)
+	}
+	sort.Strings(topics)
+	for _, t := range topics {
+		fmt.Fprintf(w, "pending %s\t%d\n", t, rep.Topics[t])
+	}
+	return w.Flush()
+}
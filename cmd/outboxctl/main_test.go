@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/gob"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sahalazain/go-common/config"
+	"github.com/sahalazain/go-common/event"
+	_ "github.com/sahalazain/simplecache/mem"
+	"github.com/stretchr/testify/assert"
+	_ "gocloud.dev/docstore/memdocstore"
+	"gocloud.dev/pubsub"
+	_ "gocloud.dev/pubsub/mempubsub"
+)
+
+func TestRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "outboxctl")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	// every command opens and closes the collection, file keeps records in between. File is written with gob,
+	// which needs concrete types of record fields
+	gob.Register(time.Time{})
+	gob.Register(map[string]interface{}{})
+	gob.Register(map[string]string{})
+	colURL := "mem://outboxctl/_id?filename=" + filepath.Join(dir, "outbox.gob")
+	os.Setenv("COLLECTION_URL", colURL)
+	os.Setenv("PUBSUB_URL", "mem://$TOPIC")
+	defer os.Unsetenv("COLLECTION_URL")
+	defer os.Unsetenv("PUBSUB_URL")
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	conf, err := config.Load(map[string]interface{}{
+		"collection_url": colURL,
+		"cache_url":      "mem://outboxctl",
+	}, "")
+	assert.Nil(t, err)
+
+	out, err := event.NewOutboxEmitter(ctx, conf)
+	assert.Nil(t, err)
+	assert.Nil(t, out.Push(ctx, "picked", "P1", map[string]interface{}{"seq": 1}, nil))
+	assert.Nil(t, out.Push(ctx, "picked", "P1", map[string]interface{}{"seq": 2}, nil))
+	assert.Nil(t, out.Publish(ctx, "delivered", map[string]interface{}{"seq": 3}, nil))
+	assert.Nil(t, out.Close(ctx))
+
+	cmd := func(args ...string) (string, error) {
+		var buf bytes.Buffer
+		err := run(ctx, args, &buf)
+		return buf.String(), err
+	}
+
+	s, err := cmd("stats")
+	assert.Nil(t, err)
+	assert.Regexp(t, `pending\s+3\n`, s)
+	assert.Regexp(t, `pending picked\s+2\n`, s)
+
+	s, err = cmd("list", "-topic", "delivered")
+	assert.Nil(t, err)
+	assert.Regexp(t, `(?s)^ID\s+TOPIC.*\n\S+\s+delivered\s`, s)
+
+	ins, err := event.NewOutboxInspector(ctx, conf)
+	assert.Nil(t, err)
+	records, err := ins.Pending(ctx, event.PendingFilter{Topic: "delivered"})
+	assert.Nil(t, err)
+	assert.Nil(t, ins.Close())
+	assert.Len(t, records, 1)
+
+	s, err = cmd("show", records[0].ID)
+	assert.Nil(t, err)
+	var shown struct {
+		Record  event.OutboxRecord `json:"record"`
+		Message event.EventMessage `json:"message"`
+	}
+	assert.Nil(t, json.Unmarshal([]byte(s), &shown))
+	assert.Equal(t, records[0].ID, shown.Record.ID)
+	assert.Equal(t, map[string]interface{}{"seq": float64(3)}, shown.Message.Data)
+
+	_, err = pubsub.OpenTopic(ctx, "mem://picked")
+	assert.Nil(t, err)
+	sub, err := pubsub.OpenSubscription(ctx, "mem://picked")
+	assert.Nil(t, err)
+
+	s, err = cmd("retry", "-topic", "picked")
+	assert.Nil(t, err)
+	assert.Equal(t, "2 of 2 records sent\n", s)
+	for i := 0; i < 2; i++ {
+		m, err := sub.Receive(ctx)
+		assert.Nil(t, err)
+		assert.Equal(t, "P1", m.Metadata["key"])
+		m.Ack()
+	}
+
+	_, err = cmd("delete", "-topic", "delivered")
+	assert.NotNil(t, err)
+
+	s, err = cmd("delete", "-topic", "delivered", "-yes")
+	assert.Nil(t, err)
+	assert.Equal(t, "1 records deleted\n", s)
+
+	s, err = cmd("stats")
+	assert.Nil(t, err)
+	assert.Regexp(t, `total\s+0\n`, s)
+
+	_, err = cmd("unknown")
+	assert.NotNil(t, err)
+
+	_, err = cmd()
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,255 @@
+package event
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sync"
+
+	"github.com/linkedin/goavro/v2"
+)
+
+const avroContentType = "application/avro"
+
+// avroCodecs avro codecs keyed by schema file, schema is read once
+var avroCodecs sync.Map
+
+// avroCodec avro codec using schema of topic data from local registry directory, <schema_dir>/<topic>.avsc.
+// Event message is encoded as record of JSON metadata string and data of topic schema
+type avroCodec struct {
+	data     *goavro.Codec
+	envelope *goavro.Codec
+	schema   interface{}
+	names    map[string]interface{}
+}
+
+func openAvroCodec(dir, topic string) (*avroCodec, error) {
+	if dir == "" {
+		return nil, errors.New("missing schema_dir param")
+	}
+
+	path := filepath.Join(dir, topic+".avsc")
+	if c, ok := avroCodecs.Load(path); ok {
+		return c.(*avroCodec), nil
+	}
+
+	schema, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := goavro.NewCodecForStandardJSON(string(schema))
+	if err != nil {
+		return nil, err
+	}
+
+	envelope, err := goavro.NewCodecForStandardJSON(fmt.Sprintf(`{"type":"record","name":"EventMessage","namespace":"event","fields":[{"name":"metadata","type":"string"},{"name":"data","type":%s}]}`, schema))
+	if err != nil {
+		return nil, err
+	}
+
+	var parsed interface{}
+	if err := json.Unmarshal(schema, &parsed); err != nil {
+		return nil, err
+	}
+
+	ac := &avroCodec{data: data, envelope: envelope, schema: parsed, names: make(map[string]interface{})}
+	ac.collectNames(parsed)
+
+	c, _ := avroCodecs.LoadOrStore(path, ac)
+	return c.(*avroCodec), nil
+}
+
+func (a *avroCodec) ContentType() string {
+	return avroContentType
+}
+
+func (a *avroCodec) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(*EventMessage)
+	if !ok {
+		native, err := a.native(v)
+		if err != nil {
+			return nil, err
+		}
+		return a.data.BinaryFromNative(nil, native)
+	}
+
+	native, err := a.native(m.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	md, err := json.Marshal(m.Metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	return a.envelope.BinaryFromNative(nil, map[string]interface{}{
+		"metadata": string(md),
+		"data":     native,
+	})
+}
+
+// native convert value to avro native form through its JSON encoding
+func (a *avroCodec) native(v interface{}) (interface{}, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	native, _, err := a.data.NativeFromTextual(b)
+	return native, err
+}
+
+func (a *avroCodec) Unmarshal(b []byte, v interface{}) error {
+	m, ok := v.(*EventMessage)
+	if !ok {
+		native, _, err := a.data.NativeFromBinary(b)
+		if err != nil {
+			return err
+		}
+		return a.decode(native, v)
+	}
+
+	native, _, err := a.envelope.NativeFromBinary(b)
+	if err != nil {
+		return err
+	}
+
+	rec, ok := native.(map[string]interface{})
+	if !ok {
+		return errors.New("[Codec] invalid avro event message")
+	}
+
+	if md, ok := rec["metadata"].(string); ok && md != "" {
+		if err := json.Unmarshal([]byte(md), &m.Metadata); err != nil {
+			return err
+		}
+	}
+
+	return a.decode(rec["data"], &m.Data)
+}
+
+func (a *avroCodec) decode(native interface{}, v interface{}) error {
+	b, err := json.Marshal(a.plain(a.schema, native))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// collectNames collect named record schemas so later references by name can be resolved
+func (a *avroCodec) collectNames(schema interface{}) {
+	switch s := schema.(type) {
+	case []interface{}:
+		for _, b := range s {
+			a.collectNames(b)
+		}
+	case map[string]interface{}:
+		if name, ok := s["name"].(string); ok {
+			a.names[name] = s
+			if ns, ok := s["namespace"].(string); ok && ns != "" {
+				a.names[ns+"."+name] = s
+			}
+		}
+		if fields, ok := s["fields"].([]interface{}); ok {
+			for _, f := range fields {
+				if fm, ok := f.(map[string]interface{}); ok {
+					a.collectNames(fm["type"])
+				}
+			}
+		}
+		if items, ok := s["items"]; ok {
+			a.collectNames(items)
+		}
+		if values, ok := s["values"]; ok {
+			a.collectNames(values)
+		}
+	}
+}
+
+// plain unwrap avro native unions following schema, so value can be decoded as standard JSON
+func (a *avroCodec) plain(schema interface{}, v interface{}) interface{} {
+	switch s := schema.(type) {
+	case string:
+		if named, ok := a.names[s]; ok {
+			return a.plain(named, v)
+		}
+		return v
+	case []interface{}:
+		u, ok := v.(map[string]interface{})
+		if !ok || len(u) != 1 {
+			return v
+		}
+		for name, val := range u {
+			for _, b := range s {
+				if avroTypeName(b) == name {
+					return a.plain(b, val)
+				}
+			}
+			return val
+		}
+	case map[string]interface{}:
+		switch s["type"] {
+		case "record":
+			rec, ok := v.(map[string]interface{})
+			if !ok {
+				return v
+			}
+			fields, _ := s["fields"].([]interface{})
+			out := make(map[string]interface{}, len(rec))
+			for _, f := range fields {
+				fm, _ := f.(map[string]interface{})
+				name, _ := fm["name"].(string)
+				if val, ok := rec[name]; ok {
+					out[name] = a.plain(fm["type"], val)
+				}
+			}
+			return out
+		case "array":
+			arr, ok := v.([]interface{})
+			if !ok {
+				return v
+			}
+			out := make([]interface{}, len(arr))
+			for i, val := range arr {
+				out[i] = a.plain(s["items"], val)
+			}
+			return out
+		case "map":
+			m, ok := v.(map[string]interface{})
+			if !ok {
+				return v
+			}
+			out := make(map[string]interface{}, len(m))
+			for k, val := range m {
+				out[k] = a.plain(s["values"], val)
+			}
+			return out
+		}
+		if t, ok := s["type"].(string); ok {
+			return a.plain(t, v)
+		}
+	}
+	return v
+}
+
+// avroTypeName name of union branch as used by goavro native form
+func avroTypeName(schema interface{}) string {
+	switch s := schema.(type) {
+	case string:
+		return s
+	case map[string]interface{}:
+		name, _ := s["name"].(string)
+		if ns, ok := s["namespace"].(string); ok && ns != "" && name != "" {
+			return ns + "." + name
+		}
+		if name != "" {
+			return name
+		}
+		t, _ := s["type"].(string)
+		return t
+	}
+	return ""
+}
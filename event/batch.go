@@ -0,0 +1,299 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/imdario/mergo"
+	"gocloud.dev/docstore"
+	"gocloud.dev/gcerrors"
+)
+
+// Event single entry of event batch, entry with key is published sequentially like Push
+type Event struct {
+	Name     string                 `json:"name,omitempty" mapstructure:"name"`
+	Key      string                 `json:"key,omitempty" mapstructure:"key"`
+	Message  interface{}            `json:"message,omitempty" mapstructure:"message"`
+	Metadata map[string]interface{} `json:"metadata,omitempty" mapstructure:"metadata"`
+	// IdempotencyKey event with the same key is delivered once per topic within dedupe window
+	IdempotencyKey string `json:"idempotency_key,omitempty" mapstructure:"idempotency_key"`
+}
+
+// BatchError errors of failed batch entries, keyed by entry index
+type BatchError map[int]error
+
+func (e BatchError) Error() string {
+	idx := make([]int, 0, len(e))
+	for i := range e {
+		idx = append(idx, i)
+	}
+	sort.Ints(idx)
+
+	if len(idx) == 0 {
+		return "[Emitter] batch failed"
+	}
+	return fmt.Sprintf("[Emitter] %d batch events failed, event %d: %v", len(idx), idx[0], e[idx[0]])
+}
+
+// errorAt return error of single entry batch
+func errorAt(err error, i int) error {
+	if berr, ok := err.(BatchError); ok {
+		return berr[i]
+	}
+	return err
+}
+
+// resolve resolve topic, metadata and key of batch events, sequential events of the same key are chained within batch.
+// Entries that fail are left nil. Chain and idempotency keys of the batch stay locked until returned unlock is called,
+// which must be after chains are advanced and idempotency keys remembered
+func (c *EventConfig) resolve(ctx context.Context, ec *EmitterCache, events []Event) ([]*Envelope, BatchError, func()) {
+	envs := make([]*Envelope, len(events))
+	errs := make(BatchError)
+	previous := make(map[string]chainState)
+	idempotent := make(map[string]bool)
+
+	mds := make([]map[string]interface{}, len(events))
+	keys := c.chainKeys(events)
+	for i, ev := range events {
+		md := c.getMetadata(c.getTopic(ev.Name))
+		if ev.Metadata != nil {
+			if err := mergo.Merge(&md, ev.Metadata); err != nil {
+				errs[i] = err
+				continue
+			}
+		}
+		mds[i] = md
+
+		// duplicates published concurrently are checked one after another
+		if ikey := idempotencyKey(ev, md); ikey != "" {
+			keys = append(keys, c.getTopic(ev.Name)+"/idempotency/"+ikey)
+		}
+	}
+	unlock := ec.lockKeys(keys)
+
+	for i, ev := range events {
+		md := mds[i]
+		if md == nil {
+			continue
+		}
+		topic := c.getTopic(ev.Name)
+
+		// duplicate is accepted without being delivered again
+		ikey := idempotencyKey(ev, md)
+		if ikey != "" {
+			if idempotent[topic+"/"+ikey] || ec.seen(ctx, topic, ikey) {
+				continue
+			}
+			idempotent[topic+"/"+ikey] = true
+			md[idempotencyMetadataKey] = ikey
+		}
+
+		codec, err := c.codec(topic)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+
+		var encryption *EncryptionConfig
+		if c.Encryption.enabled(topic) {
+			if _, ok := codec.(*avroCodec); ok {
+				errs[i] = errors.New("[Encryption] avro codec does not support encrypted data")
+				continue
+			}
+			encryption = &c.Encryption
+		}
+
+		var claimCheck *ClaimCheckConfig
+		if c.ClaimCheck.enabled() {
+			claimCheck = &c.ClaimCheck
+		}
+
+		encoding, minSize, err := c.Compression.encoding(topic)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+
+		mhash, err := hashCodec(codec, ev.Message)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+
+		// hash of plain data in clear metadata would confirm a guessed value of encrypted data
+		if encryption != nil {
+			if mhash, err = opaqueHash(); err != nil {
+				errs[i] = err
+				continue
+			}
+		}
+
+		md["hash"] = mhash
+
+		env := &Envelope{
+			Event:          ev.Name,
+			Topic:          topic,
+			Key:            ev.Key,
+			Hash:           mhash,
+			Message:        ev.Message,
+			IdempotencyKey: ikey,
+			Metadata:       md,
+			codec:          codec,
+			encoding:       encoding,
+			minSize:        minSize,
+			encryption:     encryption,
+			claimCheck:     claimCheck,
+		}
+		env.setHeader(contentTypeHeader, codec.ContentType())
+
+		if env.Key == "" {
+			env.Key = mhash
+		} else {
+			env.Sequential = true
+			prev, ok := previous[topic+env.Key]
+			if !ok {
+				prev = ec.getChain(ctx, topic+env.Key)
+			}
+			env.previous = prev
+			env.Sequence = prev.Sequence + 1
+			md["previous"] = prev.Hash
+			md[sequenceMetadataKey] = env.Sequence
+			previous[topic+env.Key] = chainState{Hash: mhash, Sequence: env.Sequence}
+		}
+		env.Group = groupOf(env)
+
+		envs[i] = env
+	}
+
+	return envs, errs, unlock
+}
+
+// outboxDocument document stored in outbox collection, in either record layout
+type outboxDocument interface {
+	documentID() string
+	createdAt() time.Time
+	setCreatedAt(t time.Time)
+}
+
+// documents outbox records as outbox documents
+func documents(records []*OutboxRecord) []outboxDocument {
+	docs := make([]outboxDocument, len(records))
+	for i, o := range records {
+		docs[i] = o
+	}
+	return docs
+}
+
+// storeRecords store outbox documents in bulk, documents already stored are skipped.
+// Returns errors of failed documents and whether each document is newly created.
+func storeRecords(ctx context.Context, col *docstore.Collection, records []outboxDocument) (BatchError, []bool) {
+	errs := make(BatchError)
+	created := make([]bool, len(records))
+
+	// action list must not refer to the same document twice, duplicates are skipped
+	ids := make(map[string]bool, len(records))
+	idx := make([]int, 0, len(records))
+	gets := col.Actions()
+	for i, o := range records {
+		if ids[o.documentID()] {
+			continue
+		}
+		ids[o.documentID()] = true
+		idx = append(idx, i)
+		gets.Get(o)
+	}
+
+	if err := gets.Do(ctx); err != nil {
+		alerr, ok := err.(docstore.ActionListError)
+		if !ok {
+			for _, i := range idx {
+				errs[i] = err
+			}
+			return errs, created
+		}
+		for _, e := range alerr {
+			if gcerrors.Code(e.Err) != gcerrors.NotFound {
+				errs[idx[e.Index]] = e.Err
+			}
+		}
+	}
+
+	now := time.Now()
+	creates := col.Actions()
+	cidx := make([]int, 0, len(idx))
+	for _, i := range idx {
+		o := records[i]
+		if errs[i] != nil || !o.createdAt().IsZero() {
+			continue
+		}
+		o.setCreatedAt(now)
+		creates.Create(o)
+		cidx = append(cidx, i)
+	}
+
+	if len(cidx) == 0 {
+		return errs, created
+	}
+
+	for _, i := range cidx {
+		created[i] = true
+	}
+
+	if err := creates.Do(ctx); err != nil {
+		alerr, ok := err.(docstore.ActionListError)
+		if !ok {
+			for _, i := range cidx {
+				errs[i] = err
+				created[i] = false
+			}
+			return errs, created
+		}
+		for _, e := range alerr {
+			i := cidx[e.Index]
+			created[i] = false
+			// stored concurrently by another emitter
+			if gcerrors.Code(e.Err) != gcerrors.AlreadyExists {
+				errs[i] = e.Err
+			}
+		}
+	}
+
+	return errs, created
+}
+
+// toRecords build outbox records of resolved events, returns event index of each record
+func toRecords(ctx context.Context, envs []*Envelope, errs BatchError) ([]*OutboxRecord, []int) {
+	records := make([]*OutboxRecord, 0, len(envs))
+	idx := make([]int, 0, len(envs))
+
+	for i, env := range envs {
+		if env == nil {
+			continue
+		}
+
+		b, err := env.toBytes(ctx)
+		if err != nil {
+			errs[i] = err
+			continue
+		}
+
+		o := &OutboxRecord{
+			GroupID:    env.Group,
+			KafkaKey:   env.Key,
+			KafkaTopic: env.Topic,
+			Headers:    env.Headers,
+		}
+		o.setValue(b, env.binary())
+		if o.ID, err = recordID(env); err != nil {
+			errs[i] = err
+			continue
+		}
+		records = append(records, o)
+		idx = append(idx, i)
+	}
+
+	return records, idx
+}
@@ -0,0 +1,109 @@
+package event
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/sahalazain/go-common/config"
+	_ "github.com/sahalazain/simplecache/mem"
+	"github.com/stretchr/testify/assert"
+	"gocloud.dev/docstore"
+	_ "gocloud.dev/docstore/memdocstore"
+	"gocloud.dev/pubsub"
+	_ "gocloud.dev/pubsub/mempubsub"
+)
+
+func TestOutboxPublishBatch(t *testing.T) {
+	cfg := map[string]interface{}{
+		"collection_url": "mem://outboxbatch/_id",
+		"cache_url":      "mem://obc",
+	}
+	conf, err := config.Load(cfg, "")
+	assert.Nil(t, err)
+
+	ctx := context.Background()
+
+	out, err := NewOutboxEmitter(ctx, conf)
+	assert.Nil(t, err)
+
+	obj := map[string]interface{}{"parcel": "P1"}
+	err = out.PublishBatch(ctx, []Event{
+		{Name: "manifest", Message: obj},
+		{Name: "manifest", Message: obj},
+		{Name: "parcel", Key: "P1", Message: map[string]interface{}{"status": "picked"}},
+		{Name: "parcel", Key: "P1", Message: map[string]interface{}{"status": "delivered"}},
+	})
+	assert.Nil(t, err)
+
+	col, err := docstore.OpenCollection(ctx, "mem://outboxbatch/_id")
+	assert.Nil(t, err)
+
+	iter := col.Query().Where("kafka_topic", "=", "parcel").Get(ctx)
+	defer iter.Stop()
+
+	prev := map[string]bool{}
+	count := 0
+	for {
+		var o OutboxRecord
+		err := iter.Next(ctx, &o)
+		if err == io.EOF {
+			break
+		}
+		assert.Nil(t, err)
+		count++
+
+		var msg EventMessage
+		assert.Nil(t, json.Unmarshal([]byte(o.KafkaValue), &msg))
+		prev[msg.Metadata["previous"].(string)] = true
+	}
+	assert.Equal(t, 2, count)
+	// second event is chained to the first one
+	assert.Len(t, prev, 2)
+	assert.True(t, prev[""])
+
+	mhash, err := hash(map[string]interface{}{"status": "delivered"})
+	assert.Nil(t, err)
+	assert.Equal(t, mhash, out.ec.getPrevious(ctx, "parcelP1"))
+}
+
+func TestPubsubPublishBatch(t *testing.T) {
+	cfg := map[string]interface{}{
+		"cache_url":  "mem://pbc",
+		"pubsub_url": "mem://$TOPIC",
+	}
+	conf, err := config.Load(cfg, "")
+	assert.Nil(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err = pubsub.OpenTopic(ctx, "mem://batch")
+	assert.Nil(t, err)
+
+	sub, err := pubsub.OpenSubscription(ctx, "mem://batch")
+	assert.Nil(t, err)
+
+	ps, err := NewPubSubEmitter(ctx, conf)
+	assert.Nil(t, err)
+
+	err = ps.PublishBatch(ctx, []Event{
+		{Name: "batch", Message: map[string]interface{}{"seq": 1}},
+		{Name: "broken?invalid=1", Message: map[string]interface{}{"seq": 2}},
+		{Name: "batch", Key: "P1", Message: map[string]interface{}{"seq": 3}},
+	})
+	assert.NotNil(t, err)
+
+	berr, ok := err.(BatchError)
+	assert.True(t, ok)
+	assert.Len(t, berr, 1)
+	assert.NotNil(t, berr[1])
+
+	for i := 0; i < 2; i++ {
+		m, err := sub.Receive(ctx)
+		assert.Nil(t, err)
+		m.Ack()
+	}
+}
@@ -0,0 +1,138 @@
+package event
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"sort"
+	"sync"
+)
+
+// sequenceMetadataKey metadata entry carrying sequence number of sequential event within its key
+const sequenceMetadataKey = "sequence"
+
+// ErrChainConflict returned when chain of key was advanced by another emitter since it was read
+var ErrChainConflict = errors.New("[Emitter] event chain was advanced concurrently")
+
+// chainState last event of key chain, stored in emitter cache
+type chainState struct {
+	Hash     string `json:"hash"`
+	Sequence int64  `json:"sequence"`
+}
+
+// keyLock per key mutex, removed once nobody holds or waits for it
+type keyLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+// lockKeys lock chain keys in sorted order so batches sharing keys can not deadlock, returns unlock func
+func (e *EmitterCache) lockKeys(keys []string) func() {
+	sort.Strings(keys)
+
+	uniq := make([]string, 0, len(keys))
+	for n, k := range keys {
+		if n == 0 || keys[n-1] != k {
+			uniq = append(uniq, k)
+		}
+	}
+
+	locks := make([]*keyLock, len(uniq))
+	for n, k := range uniq {
+		e.lmu.Lock()
+		if e.locks == nil {
+			e.locks = make(map[string]*keyLock)
+		}
+		l, ok := e.locks[k]
+		if !ok {
+			l = &keyLock{}
+			e.locks[k] = l
+		}
+		l.refs++
+		e.lmu.Unlock()
+
+		l.mu.Lock()
+		locks[n] = l
+	}
+
+	return func() {
+		e.lmu.Lock()
+		defer e.lmu.Unlock()
+
+		for n, k := range uniq {
+			locks[n].mu.Unlock()
+			if locks[n].refs--; locks[n].refs == 0 {
+				delete(e.locks, k)
+			}
+		}
+	}
+}
+
+// getChain read chain state of key, value stored as plain hash by older emitters has no sequence
+func (e *EmitterCache) getChain(ctx context.Context, event string) chainState {
+	if e.cache == nil {
+		return chainState{}
+	}
+
+	v, _ := e.cache.GetString(ctx, e.keyName+event)
+	if v == "" {
+		return chainState{}
+	}
+
+	var s chainState
+	if err := json.Unmarshal([]byte(v), &s); err != nil {
+		return chainState{Hash: v}
+	}
+	return s
+}
+
+// advance move chain of key from previous to current state. Key lock serializes emitters of this process, forks by
+// other processes are detected on a best effort basis only: simplecache has no compare and swap, so state is compared
+// before it is written and a process writing in between goes unnoticed
+func (e *EmitterCache) advance(ctx context.Context, event string, previous, current chainState) error {
+	if e.cache == nil {
+		return errors.New("[Emitter] empty cache")
+	}
+
+	if e.getChain(ctx, event) != previous {
+		return ErrChainConflict
+	}
+
+	b, err := json.Marshal(current)
+	if err != nil {
+		return err
+	}
+	return e.cache.Set(ctx, e.keyName+event, string(b), 0)
+}
+
+// chainKeys chain keys of sequential events in batch
+func (c *EventConfig) chainKeys(events []Event) []string {
+	keys := make([]string, 0, len(events))
+	for _, ev := range events {
+		if ev.Key != "" {
+			keys = append(keys, c.getTopic(ev.Name)+ev.Key)
+		}
+	}
+	return keys
+}
+
+// checkChain whether chain of sequential envelope is still at state it was resolved against, checked under key lock
+// before sending so an event chained by another process meanwhile is not sent. Best effort as advance
+func (e *EmitterCache) checkChain(ctx context.Context, env *Envelope) error {
+	if !env.Sequential || e.cache == nil {
+		return nil
+	}
+
+	if e.getChain(ctx, env.Topic+env.Key) != env.previous {
+		return ErrChainConflict
+	}
+	return nil
+}
+
+// advanceChain store sequential envelope as the last event of its key
+func (e *EmitterCache) advanceChain(ctx context.Context, env *Envelope) error {
+	if !env.Sequential {
+		return nil
+	}
+	return e.advance(ctx, env.Topic+env.Key, env.previous, chainState{Hash: env.Hash, Sequence: env.Sequence})
+}
@@ -0,0 +1,116 @@
+package event
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/sahalazain/go-common/config"
+	_ "github.com/sahalazain/simplecache/mem"
+	"github.com/stretchr/testify/assert"
+	"gocloud.dev/docstore"
+	_ "gocloud.dev/docstore/memdocstore"
+)
+
+func TestConcurrentPush(t *testing.T) {
+	cfg := map[string]interface{}{
+		"collection_url": "mem://chained/_id",
+		"cache_url":      "mem://chc",
+	}
+	conf, err := config.Load(cfg, "")
+	assert.Nil(t, err)
+
+	ctx := context.Background()
+
+	out, err := NewOutboxEmitter(ctx, conf)
+	assert.Nil(t, err)
+
+	const pushes = 50
+	start := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < pushes; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			<-start
+			assert.Nil(t, out.Push(ctx, "chained", "P1", map[string]interface{}{"n": i}, nil))
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+
+	col, err := docstore.OpenCollection(ctx, "mem://chained/_id")
+	assert.Nil(t, err)
+
+	iter := col.Query().Get(ctx)
+	defer iter.Stop()
+
+	previous := map[string]int{}
+	hashes := map[string]bool{}
+	sequences := map[int64]bool{}
+	for {
+		var o OutboxRecord
+		err := iter.Next(ctx, &o)
+		if err == io.EOF {
+			break
+		}
+		assert.Nil(t, err)
+
+		var msg struct {
+			Metadata struct {
+				Hash     string `json:"hash"`
+				Previous string `json:"previous"`
+				Sequence int64  `json:"sequence"`
+			} `json:"metadata"`
+		}
+		assert.Nil(t, json.Unmarshal([]byte(o.KafkaValue), &msg))
+
+		previous[msg.Metadata.Previous]++
+		hashes[msg.Metadata.Hash] = true
+		sequences[msg.Metadata.Sequence] = true
+	}
+
+	// single chain without fork
+	assert.Len(t, previous, pushes)
+	assert.Len(t, sequences, pushes)
+	assert.Equal(t, 1, previous[""])
+	for p, n := range previous {
+		assert.Equal(t, 1, n)
+		assert.True(t, p == "" || hashes[p])
+	}
+	for s := int64(1); s <= pushes; s++ {
+		assert.True(t, sequences[s])
+	}
+
+	last := out.ec.getChain(ctx, "chainedP1")
+	assert.Equal(t, int64(pushes), last.Sequence)
+	assert.True(t, hashes[last.Hash])
+
+	out.ec.lmu.Lock()
+	assert.Empty(t, out.ec.locks)
+	out.ec.lmu.Unlock()
+
+	assert.Nil(t, out.Close(ctx))
+}
+
+func TestChainConflict(t *testing.T) {
+	ec, err := NewEmitterCache("mem://chcc")
+	assert.Nil(t, err)
+
+	ctx := context.Background()
+
+	// value stored by older emitter
+	assert.Nil(t, ec.cache.Set(ctx, ec.keyName+"parcelP1", "h1", 0))
+	first := ec.getChain(ctx, "parcelP1")
+	assert.Equal(t, chainState{Hash: "h1"}, first)
+
+	second := chainState{Hash: "h2", Sequence: 1}
+	assert.Nil(t, ec.advance(ctx, "parcelP1", first, second))
+	assert.Equal(t, "h2", ec.getPrevious(ctx, "parcelP1"))
+
+	// another emitter read the same state before it was advanced
+	assert.Equal(t, ErrChainConflict, ec.advance(ctx, "parcelP1", first, chainState{Hash: "h3", Sequence: 1}))
+	assert.Equal(t, second, ec.getChain(ctx, "parcelP1"))
+}
@@ -0,0 +1,149 @@
+package event
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"time"
+
+	"gocloud.dev/blob"
+)
+
+const (
+	claimCheckHeader   = "claim-check"
+	claimCheckMetadata = "claim_check"
+)
+
+// ClaimCheckConfig offload encoded events larger than threshold bytes to blob bucket,
+// only small reference envelope carrying event metadata and blob key, size and hash is published
+type ClaimCheckConfig struct {
+	BucketURL string `json:"bucket_url,omitempty" mapstructure:"bucket_url"`
+	Threshold int    `json:"threshold,omitempty" mapstructure:"threshold"`
+	Prefix    string `json:"prefix,omitempty" mapstructure:"prefix"`
+	bucket    *blob.Bucket
+}
+
+// claimCheck blob reference of offloaded event
+type claimCheck struct {
+	Key  string `json:"key"`
+	Size int    `json:"size"`
+	Hash string `json:"hash"`
+}
+
+// openBucket open configured blob bucket
+func (c *ClaimCheckConfig) openBucket(ctx context.Context) error {
+	if c.BucketURL == "" || c.bucket != nil {
+		return nil
+	}
+
+	b, err := blob.OpenBucket(ctx, c.BucketURL)
+	if err != nil {
+		return err
+	}
+	c.bucket = b
+	return nil
+}
+
+// closeBucket close opened blob bucket
+func (c *ClaimCheckConfig) closeBucket() error {
+	if c.bucket == nil {
+		return nil
+	}
+	return c.bucket.Close()
+}
+
+func (c *ClaimCheckConfig) enabled() bool {
+	return c.bucket != nil && c.Threshold > 0
+}
+
+// offload upload body to bucket and return reference envelope of it
+func (c *ClaimCheckConfig) offload(ctx context.Context, topic string, body []byte, metadata map[string]interface{}) ([]byte, *claimCheck, error) {
+	sum := sha256.Sum256(body)
+	h := base64.RawURLEncoding.EncodeToString(sum[:])
+
+	cc := &claimCheck{
+		Key:  c.Prefix + topic + "/" + h,
+		Size: len(body),
+		Hash: h,
+	}
+
+	if err := c.bucket.WriteAll(ctx, cc.Key, body, &blob.WriterOptions{ContentType: "application/octet-stream"}); err != nil {
+		return nil, nil, err
+	}
+
+	md := make(map[string]interface{}, len(metadata)+1)
+	for k, v := range metadata {
+		md[k] = v
+	}
+	md[claimCheckMetadata] = cc
+
+	ref, err := json.Marshal(&EventMessage{Metadata: md})
+	if err != nil {
+		return nil, nil, err
+	}
+	return ref, cc, nil
+}
+
+// fetch download body of reference envelope and verify its size and hash
+func (c *ClaimCheckConfig) fetch(ctx context.Context, ref []byte) ([]byte, error) {
+	if c.bucket == nil {
+		return nil, errors.New("missing bucket_url param")
+	}
+
+	var env struct {
+		Metadata struct {
+			ClaimCheck claimCheck `json:"claim_check"`
+		} `json:"metadata"`
+	}
+	if err := json.Unmarshal(ref, &env); err != nil {
+		return nil, err
+	}
+	cc := env.Metadata.ClaimCheck
+
+	body, err := c.bucket.ReadAll(ctx, cc.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256(body)
+	if len(body) != cc.Size || base64.RawURLEncoding.EncodeToString(sum[:]) != cc.Hash {
+		return nil, errors.New("[ClaimCheck] blob does not match reference")
+	}
+	return body, nil
+}
+
+// CleanupClaimChecks delete offloaded event blobs under prefix older than age, returns number of deleted blobs.
+// Age should exceed the longest time an event may wait in outbox and topic before being consumed
+func CleanupClaimChecks(ctx context.Context, bucketURL, prefix string, age time.Duration) (int, error) {
+	b, err := blob.OpenBucket(ctx, bucketURL)
+	if err != nil {
+		return 0, err
+	}
+	defer b.Close()
+
+	before := time.Now().Add(-age)
+	iter := b.List(&blob.ListOptions{Prefix: prefix})
+
+	n := 0
+	for {
+		obj, err := iter.Next(ctx)
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return n, err
+		}
+
+		if obj.IsDir || !obj.ModTime.Before(before) {
+			continue
+		}
+
+		if err := b.Delete(ctx, obj.Key); err != nil {
+			return n, err
+		}
+		n++
+	}
+}
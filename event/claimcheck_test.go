@@ -0,0 +1,96 @@
+package event
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sahalazain/go-common/config"
+	_ "github.com/sahalazain/simplecache/mem"
+	"github.com/stretchr/testify/assert"
+	_ "gocloud.dev/blob/fileblob"
+	"gocloud.dev/pubsub"
+	_ "gocloud.dev/pubsub/mempubsub"
+)
+
+func TestClaimCheck(t *testing.T) {
+	bucketURL := "file://" + t.TempDir()
+
+	cfg := map[string]interface{}{
+		"cache_url":  "mem://ccc",
+		"pubsub_url": "mem://$TOPIC",
+		"config": map[string]interface{}{
+			"claim_check": map[string]interface{}{
+				"bucket_url": bucketURL,
+				"threshold":  256,
+				"prefix":     "events/",
+			},
+		},
+	}
+	conf, err := config.Load(cfg, "")
+	assert.Nil(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err = pubsub.OpenTopic(ctx, "mem://bigmanifest")
+	assert.Nil(t, err)
+
+	raw, err := pubsub.OpenSubscription(ctx, "mem://bigmanifest")
+	assert.Nil(t, err)
+
+	ps, err := NewPubSubEmitter(ctx, conf)
+	assert.Nil(t, err)
+
+	large := map[string]interface{}{"parcels": strings.Repeat("P1,", 500)}
+	assert.Nil(t, ps.Push(ctx, "bigmanifest", "M1", large, nil))
+
+	m, err := raw.Receive(ctx)
+	assert.Nil(t, err)
+	m.Ack()
+	assert.True(t, strings.HasPrefix(m.Metadata[claimCheckHeader], "events/bigmanifest/"))
+	assert.True(t, len(m.Body) < 512)
+	assert.Nil(t, raw.Shutdown(ctx))
+
+	sub, err := NewSubscriber(ctx, conf)
+	assert.Nil(t, err)
+
+	received := make(chan map[string]interface{}, 2)
+	err = sub.Subscribe(ctx, "bigmanifest", func(ctx context.Context, msg *EventMessage) error {
+		var m map[string]interface{}
+		if err := msg.Decode(&m); err != nil {
+			return err
+		}
+		received <- m
+		return nil
+	})
+	assert.Nil(t, err)
+
+	assert.Nil(t, ps.Push(ctx, "bigmanifest", "M1", large, nil))
+	assert.Nil(t, ps.Push(ctx, "bigmanifest", "M1", map[string]interface{}{"parcels": "P2"}, nil))
+
+	got := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		select {
+		case m := <-received:
+			got[m["parcels"].(string)] = true
+		case <-ctx.Done():
+			t.Fatal("event not received")
+		}
+	}
+	assert.True(t, got[large["parcels"].(string)])
+	assert.True(t, got["P2"])
+
+	assert.Nil(t, sub.Close(ctx))
+	assert.Nil(t, ps.Close(ctx))
+
+	n, err := CleanupClaimChecks(ctx, bucketURL, "events/", time.Hour)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n)
+
+	// large event pushed twice with different previous hash
+	n, err = CleanupClaimChecks(ctx, bucketURL, "events/", 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, n)
+}
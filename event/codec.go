@@ -0,0 +1,247 @@
+package event
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/vmihailenco/msgpack/v5"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+const (
+	contentTypeHeader = "content-type"
+	defaultCodec      = "json"
+	avroCodecName     = "avro"
+)
+
+// Codec encode and decode event payload, marshalling *EventMessage produce the broker message body
+type Codec interface {
+	// ContentType value of content-type header of encoded message
+	ContentType() string
+	Marshal(v interface{}) ([]byte, error)
+	Unmarshal(b []byte, v interface{}) error
+}
+
+var (
+	codecsMu sync.RWMutex
+	codecs   = map[string]Codec{
+		"json":     jsonCodec{},
+		"msgpack":  msgpackCodec{},
+		"protobuf": protobufCodec{},
+	}
+)
+
+// RegisterCodec register codec under name so it can be selected in event config
+func RegisterCodec(name string, c Codec) {
+	codecsMu.Lock()
+	defer codecsMu.Unlock()
+	codecs[name] = c
+}
+
+func getCodec(name string) (Codec, error) {
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	c, ok := codecs[name]
+	if !ok {
+		return nil, fmt.Errorf("[Codec] unsupported codec %q", name)
+	}
+	return c, nil
+}
+
+// codec return codec configured for topic, json by default
+func (c *EventConfig) codec(topic string) (Codec, error) {
+	name, ok := c.Codecs[topic]
+	if !ok {
+		name = c.Codecs["default"]
+	}
+	if name == "" {
+		name = defaultCodec
+	}
+
+	if name == avroCodecName {
+		return openAvroCodec(c.SchemaDir, topic)
+	}
+	return getCodec(name)
+}
+
+// contentCodec return codec of received message content type, messages without content type use configured codec
+func (c *EventConfig) contentCodec(topic, contentType string) (Codec, error) {
+	if contentType == "" {
+		return c.codec(topic)
+	}
+
+	if contentType == avroContentType {
+		return openAvroCodec(c.SchemaDir, topic)
+	}
+
+	codecsMu.RLock()
+	defer codecsMu.RUnlock()
+	for _, cd := range codecs {
+		if cd.ContentType() == contentType {
+			return cd, nil
+		}
+	}
+	return nil, fmt.Errorf("[Codec] unsupported content type %q", contentType)
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) ContentType() string {
+	return "application/json"
+}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(b []byte, v interface{}) error {
+	return json.Unmarshal(b, v)
+}
+
+// msgpackCodec MessagePack codec, struct fields are named after json tags and map keys are sorted for stable hash
+type msgpackCodec struct{}
+
+func (msgpackCodec) ContentType() string {
+	return "application/msgpack"
+}
+
+func (msgpackCodec) Marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	enc.SetSortMapKeys(true)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (msgpackCodec) Unmarshal(b []byte, v interface{}) error {
+	dec := msgpack.NewDecoder(bytes.NewReader(b))
+	dec.SetCustomStructTag("json")
+	return dec.Decode(v)
+}
+
+var errNotProto = errors.New("[Codec] protobuf payload must be proto.Message")
+
+// protobufCodec protobuf codec, event message is encoded as
+//
+//	message EventMessage { bytes data = 1; google.protobuf.Struct metadata = 2; }
+//
+// Decoded message data is left as raw bytes unless it is set to proto.Message before decoding
+type protobufCodec struct{}
+
+func (protobufCodec) ContentType() string {
+	return "application/x-protobuf"
+}
+
+func (p protobufCodec) Marshal(v interface{}) ([]byte, error) {
+	m, ok := v.(*EventMessage)
+	if !ok {
+		return p.marshalData(v)
+	}
+
+	data, err := p.marshalData(m.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	b := protowire.AppendTag(nil, 1, protowire.BytesType)
+	b = protowire.AppendBytes(b, data)
+
+	if len(m.Metadata) > 0 {
+		mb, err := json.Marshal(m.Metadata)
+		if err != nil {
+			return nil, err
+		}
+		md := make(map[string]interface{})
+		if err := json.Unmarshal(mb, &md); err != nil {
+			return nil, err
+		}
+		st, err := structpb.NewStruct(md)
+		if err != nil {
+			return nil, err
+		}
+		sb, err := proto.MarshalOptions{Deterministic: true}.Marshal(st)
+		if err != nil {
+			return nil, err
+		}
+		b = protowire.AppendTag(b, 2, protowire.BytesType)
+		b = protowire.AppendBytes(b, sb)
+	}
+
+	return b, nil
+}
+
+func (protobufCodec) marshalData(v interface{}) ([]byte, error) {
+	switch t := v.(type) {
+	case nil:
+		return nil, nil
+	case []byte:
+		return t, nil
+	case proto.Message:
+		return proto.MarshalOptions{Deterministic: true}.Marshal(t)
+	}
+	return nil, errNotProto
+}
+
+func (protobufCodec) Unmarshal(b []byte, v interface{}) error {
+	m, ok := v.(*EventMessage)
+	if !ok {
+		switch t := v.(type) {
+		case proto.Message:
+			return proto.Unmarshal(b, t)
+		case *interface{}:
+			*t = append([]byte(nil), b...)
+			return nil
+		}
+		return errNotProto
+	}
+
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		if typ != protowire.BytesType || (num != 1 && num != 2) {
+			n = protowire.ConsumeFieldValue(num, typ, b)
+			if n < 0 {
+				return protowire.ParseError(n)
+			}
+			b = b[n:]
+			continue
+		}
+
+		val, n := protowire.ConsumeBytes(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+
+		if num == 1 {
+			if pm, ok := m.Data.(proto.Message); ok {
+				if err := proto.Unmarshal(val, pm); err != nil {
+					return err
+				}
+				continue
+			}
+			m.Data = append([]byte(nil), val...)
+			continue
+		}
+
+		var st structpb.Struct
+		if err := proto.Unmarshal(val, &st); err != nil {
+			return err
+		}
+		m.Metadata = st.AsMap()
+	}
+
+	return nil
+}
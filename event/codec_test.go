@@ -0,0 +1,129 @@
+package event
+
+import (
+	"context"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/sahalazain/go-common/config"
+	_ "github.com/sahalazain/simplecache/mem"
+	"github.com/stretchr/testify/assert"
+	"gocloud.dev/pubsub"
+	_ "gocloud.dev/pubsub/mempubsub"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+const testPersonSchema = `{"type":"record","name":"Person","fields":[{"name":"name","type":"string"},{"name":"address","type":["null","string"],"default":null}]}`
+
+func TestCodecRoundTrip(t *testing.T) {
+	dir := t.TempDir()
+	assert.Nil(t, ioutil.WriteFile(filepath.Join(dir, "person.avsc"), []byte(testPersonSchema), 0644))
+
+	conf := &EventConfig{
+		Codecs:    map[string]string{"default": "msgpack", "person": "avro", "raw": "json"},
+		SchemaDir: dir,
+	}
+
+	md := map[string]interface{}{"event": "person", "previous": "abc"}
+	person := testPerson{Name: "SiCepat", Address: "Jakarta"}
+
+	for _, topic := range []string{"person", "parcel", "raw"} {
+		c, err := conf.codec(topic)
+		assert.Nil(t, err)
+
+		b, err := c.Marshal(&EventMessage{Data: person, Metadata: md})
+		assert.Nil(t, err)
+
+		cc, err := conf.contentCodec(topic, c.ContentType())
+		assert.Nil(t, err)
+		assert.Equal(t, c, cc)
+
+		var msg EventMessage
+		assert.Nil(t, cc.Unmarshal(b, &msg))
+		assert.Equal(t, "abc", msg.Metadata["previous"])
+
+		var p testPerson
+		assert.Nil(t, msg.Decode(&p))
+		assert.Equal(t, person, p, topic)
+
+		h1, err := hashCodec(c, person)
+		assert.Nil(t, err)
+		h2, err := hashCodec(c, person)
+		assert.Nil(t, err)
+		assert.Equal(t, h1, h2)
+	}
+
+	_, err := conf.contentCodec("person", "application/xml")
+	assert.NotNil(t, err)
+
+	conf.Codecs["broken"] = "yaml"
+	_, err = conf.codec("broken")
+	assert.NotNil(t, err)
+}
+
+func TestProtobufCodec(t *testing.T) {
+	c := protobufCodec{}
+
+	b, err := c.Marshal(&EventMessage{Data: wrapperspb.String("P1"), Metadata: map[string]interface{}{"version": 1}})
+	assert.Nil(t, err)
+
+	var msg EventMessage
+	assert.Nil(t, c.Unmarshal(b, &msg))
+	assert.Equal(t, float64(1), msg.Metadata["version"])
+
+	var s wrapperspb.StringValue
+	assert.Nil(t, msg.Decode(&s))
+	assert.Equal(t, "P1", s.Value)
+
+	_, err = c.Marshal(&EventMessage{Data: map[string]interface{}{"a": 1}})
+	assert.NotNil(t, err)
+}
+
+func TestPubsubCodec(t *testing.T) {
+	cfg := map[string]interface{}{
+		"cache_url":  "mem://cdc",
+		"pubsub_url": "mem://$TOPIC",
+		"config": map[string]interface{}{
+			"codecs": map[string]interface{}{"encoded": "msgpack"},
+		},
+	}
+	conf, err := config.Load(cfg, "")
+	assert.Nil(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err = pubsub.OpenTopic(ctx, "mem://encoded")
+	assert.Nil(t, err)
+
+	ps, err := NewPubSubEmitter(ctx, conf)
+	assert.Nil(t, err)
+
+	sub, err := NewSubscriber(ctx, conf)
+	assert.Nil(t, err)
+
+	received := make(chan testPerson, 1)
+	err = sub.Subscribe(ctx, "encoded", func(ctx context.Context, msg *EventMessage) error {
+		var p testPerson
+		if err := msg.Decode(&p); err != nil {
+			return err
+		}
+		received <- p
+		return nil
+	})
+	assert.Nil(t, err)
+
+	err = ps.Publish(ctx, "encoded", testPerson{Name: "SiCepat", Address: "Jakarta"}, nil)
+	assert.Nil(t, err)
+
+	select {
+	case p := <-received:
+		assert.Equal(t, "SiCepat", p.Name)
+	case <-ctx.Done():
+		t.Fatal("event not received")
+	}
+
+	assert.Nil(t, sub.Close(ctx))
+}
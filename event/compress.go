@@ -0,0 +1,135 @@
+package event
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"sync"
+
+	"github.com/golang/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+const contentEncodingHeader = "content-encoding"
+
+// CompressionConfig event payload compression. Topics listed in events are always compressed with their algorithm,
+// other topics are compressed with default algorithm once encoded payload reach threshold bytes
+type CompressionConfig struct {
+	Events    map[string]string `json:"events,omitempty" mapstructure:"events"`
+	Algorithm string            `json:"algorithm,omitempty" mapstructure:"algorithm"`
+	Threshold int               `json:"threshold,omitempty" mapstructure:"threshold"`
+}
+
+// encoding return compression algorithm of topic and minimum payload size to compress
+func (c *CompressionConfig) encoding(topic string) (string, int, error) {
+	alg, size := c.Events[topic], 0
+	if alg == "" {
+		if c.Algorithm == "" || c.Threshold <= 0 {
+			return "", 0, nil
+		}
+		alg, size = c.Algorithm, c.Threshold
+	}
+
+	if alg == "none" {
+		return "", 0, nil
+	}
+
+	if _, ok := compressors[alg]; !ok {
+		return "", 0, fmt.Errorf("[Compression] unsupported algorithm %q", alg)
+	}
+	return alg, size, nil
+}
+
+type compressor struct {
+	compress   func(b []byte) ([]byte, error)
+	decompress func(b []byte) ([]byte, error)
+}
+
+var compressors = map[string]compressor{
+	"gzip":   {gzipCompress, gzipDecompress},
+	"snappy": {snappyCompress, snappyDecompress},
+	"zstd":   {zstdCompress, zstdDecompress},
+}
+
+func compress(alg string, b []byte) ([]byte, error) {
+	c, ok := compressors[alg]
+	if !ok {
+		return nil, fmt.Errorf("[Compression] unsupported algorithm %q", alg)
+	}
+	return c.compress(b)
+}
+
+// decompress decompress payload of content encoding, payload without encoding is returned as is
+func decompress(alg string, b []byte) ([]byte, error) {
+	if alg == "" || alg == "identity" {
+		return b, nil
+	}
+	c, ok := compressors[alg]
+	if !ok {
+		return nil, fmt.Errorf("[Compression] unsupported content encoding %q", alg)
+	}
+	return c.decompress(b)
+}
+
+func gzipCompress(b []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	if _, err := w.Write(b); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func gzipDecompress(b []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
+func snappyCompress(b []byte) ([]byte, error) {
+	return snappy.Encode(nil, b), nil
+}
+
+func snappyDecompress(b []byte) ([]byte, error) {
+	return snappy.Decode(nil, b)
+}
+
+var (
+	zstdOnce    sync.Once
+	zstdEncoder *zstd.Encoder
+	zstdDecoder *zstd.Decoder
+	zstdErr     error
+)
+
+// zstdInit create shared zstd encoder and decoder, both are safe for concurrent EncodeAll and DecodeAll
+func zstdInit() error {
+	zstdOnce.Do(func() {
+		zstdEncoder, zstdErr = zstd.NewWriter(nil)
+		if zstdErr != nil {
+			return
+		}
+		zstdDecoder, zstdErr = zstd.NewReader(nil)
+	})
+	return zstdErr
+}
+
+func zstdCompress(b []byte) ([]byte, error) {
+	if err := zstdInit(); err != nil {
+		return nil, err
+	}
+	return zstdEncoder.EncodeAll(b, nil), nil
+}
+
+func zstdDecompress(b []byte) ([]byte, error) {
+	if err := zstdInit(); err != nil {
+		return nil, err
+	}
+	return zstdDecoder.DecodeAll(b, nil)
+}
@@ -0,0 +1,141 @@
+package event
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sahalazain/go-common/config"
+	_ "github.com/sahalazain/simplecache/mem"
+	"github.com/stretchr/testify/assert"
+	"gocloud.dev/docstore"
+	_ "gocloud.dev/docstore/memdocstore"
+	"gocloud.dev/pubsub"
+	_ "gocloud.dev/pubsub/mempubsub"
+)
+
+func TestCompression(t *testing.T) {
+	payload := []byte(strings.Repeat("manifest parcel ", 100))
+
+	for alg := range compressors {
+		b, err := compress(alg, payload)
+		assert.Nil(t, err)
+		assert.True(t, len(b) < len(payload), alg)
+
+		d, err := decompress(alg, b)
+		assert.Nil(t, err)
+		assert.Equal(t, payload, d, alg)
+	}
+
+	d, err := decompress("", payload)
+	assert.Nil(t, err)
+	assert.Equal(t, payload, d)
+
+	_, err = decompress("brotli", payload)
+	assert.NotNil(t, err)
+
+	c := &CompressionConfig{
+		Events:    map[string]string{"manifest": "zstd", "small": "none"},
+		Algorithm: "gzip",
+		Threshold: 1024,
+	}
+
+	alg, size, err := c.encoding("manifest")
+	assert.Nil(t, err)
+	assert.Equal(t, "zstd", alg)
+	assert.Equal(t, 0, size)
+
+	alg, size, err = c.encoding("parcel")
+	assert.Nil(t, err)
+	assert.Equal(t, "gzip", alg)
+	assert.Equal(t, 1024, size)
+
+	alg, _, err = c.encoding("small")
+	assert.Nil(t, err)
+	assert.Equal(t, "", alg)
+
+	c.Events["broken"] = "lzma"
+	_, _, err = c.encoding("broken")
+	assert.NotNil(t, err)
+}
+
+func TestOutboxCompression(t *testing.T) {
+	cfg := map[string]interface{}{
+		"collection_url": "mem://compressed/_id",
+		"cache_url":      "mem://cpc",
+		"pubsub_url":     "mem://$TOPIC",
+		"config": map[string]interface{}{
+			"compression": map[string]interface{}{
+				"algorithm": "snappy",
+				"threshold": 512,
+			},
+		},
+	}
+	conf, err := config.Load(cfg, "")
+	assert.Nil(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err = pubsub.OpenTopic(ctx, "mem://manifest")
+	assert.Nil(t, err)
+
+	out, err := NewOutboxEmitter(ctx, conf)
+	assert.Nil(t, err)
+
+	relay, err := NewOutboxRelay(ctx, conf)
+	assert.Nil(t, err)
+
+	sub, err := NewSubscriber(ctx, conf)
+	assert.Nil(t, err)
+
+	received := make(chan map[string]interface{}, 2)
+	err = sub.Subscribe(ctx, "manifest", func(ctx context.Context, msg *EventMessage) error {
+		var m map[string]interface{}
+		if err := msg.Decode(&m); err != nil {
+			return err
+		}
+		received <- m
+		return nil
+	})
+	assert.Nil(t, err)
+
+	large := map[string]interface{}{"parcels": strings.Repeat("P1,", 500)}
+	small := map[string]interface{}{"parcels": "P2"}
+	assert.Nil(t, out.Publish(ctx, "manifest", large, nil))
+	assert.Nil(t, out.Publish(ctx, "manifest", small, nil))
+
+	col, err := docstore.OpenCollection(ctx, "mem://compressed/_id")
+	assert.Nil(t, err)
+
+	iter := col.Query().Get(ctx)
+	encodings := map[string]string{}
+	for {
+		var o OutboxRecord
+		if err := iter.Next(ctx, &o); err != nil {
+			break
+		}
+		encodings[o.Headers[contentEncodingHeader]] = o.ValueEncoding
+	}
+	iter.Stop()
+	assert.Equal(t, map[string]string{"snappy": "base64", "": ""}, encodings)
+
+	n, err := relay.Relay(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, n)
+
+	got := map[string]bool{}
+	for i := 0; i < 2; i++ {
+		select {
+		case m := <-received:
+			got[m["parcels"].(string)] = true
+		case <-ctx.Done():
+			t.Fatal("event not received")
+		}
+	}
+	assert.True(t, got[large["parcels"].(string)])
+	assert.True(t, got["P2"])
+
+	assert.Nil(t, sub.Close(ctx))
+}
@@ -0,0 +1,369 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/sahalazain/go-common/config"
+	"github.com/sahalazain/go-common/logger"
+	"go.opentelemetry.io/otel/trace"
+	"gocloud.dev/pubsub"
+	"gocloud.dev/pubsub/kafkapubsub"
+)
+
+// Handler event handler, returning nil error will ack the message, otherwise message will be nacked.
+// Message of broker that can not nack, such as kafka, is retried in place with handler_retry backoff instead,
+// and is acked and logged as dropped once max attempts run out
+type Handler func(ctx context.Context, msg *EventMessage) error
+
+//Consumer event consumer
+type Consumer interface {
+	Subscribe(ctx context.Context, event string, handler Handler) error
+	Close(ctx context.Context) error
+}
+
+//NewConsumer create event consumer instance
+func NewConsumer(ctx context.Context, conf config.Getter) (Consumer, error) {
+	if conf == nil {
+		return nil, errors.New("[Consumer] missing event_consumer param")
+	}
+	return NewSubscriber(ctx, conf)
+}
+
+//Subscriber pubsub event consumer
+type Subscriber struct {
+	Config      EventConfig `json:"config,omitempty" mapstructure:"config"`
+	PubsubURL   string      `json:"pubsub_url,omitempty" mapstructure:"pubsub_url"`
+	KafkaBroker string      `json:"kafka_broker,omitempty" mapstructure:"kafka_broker"`
+	KafkaGroup  string      `json:"kafka_group,omitempty" mapstructure:"kafka_group"`
+	Kafka       KafkaConfig `json:"kafka,omitempty" mapstructure:"kafka"`
+	MaxHandlers int         `json:"max_handlers,omitempty" mapstructure:"max_handlers"`
+	// HandlerRetry backoff of failed handler on broker that can not nack, zero max attempts retry until closed.
+	// Retryable codes and dead letter topic are not used
+	HandlerRetry RetryPolicy `json:"handler_retry,omitempty" mapstructure:"handler_retry"`
+	kafka        *sarama.Config
+	subs         []*pubsub.Subscription
+	mu           sync.Mutex
+	wg           sync.WaitGroup
+	done         chan struct{}
+}
+
+//NewSubscriber create instance of pubsub consumer
+func NewSubscriber(ctx context.Context, conf config.Getter) (*Subscriber, error) {
+	var s Subscriber
+
+	if err := conf.Unmarshal(&s); err != nil {
+		return nil, err
+	}
+
+	if s.PubsubURL == "" {
+		return nil, errors.New("missing pubsub_url param")
+	}
+
+	if s.MaxHandlers <= 0 {
+		s.MaxHandlers = 1
+	}
+	s.done = make(chan struct{})
+
+	kc, err := s.Kafka.saramaConfig()
+	if err != nil {
+		return nil, err
+	}
+	s.kafka = kc
+
+	if err := s.Config.Encryption.openKeeper(ctx); err != nil {
+		return nil, err
+	}
+
+	if err := s.Config.ClaimCheck.openBucket(ctx); err != nil {
+		return nil, err
+	}
+
+	return &s, nil
+}
+
+// Subscribe start receiving event in background and pass it to handler
+func (s *Subscriber) Subscribe(ctx context.Context, event string, handler Handler) error {
+	if handler == nil {
+		return errors.New("missing event handler")
+	}
+
+	topic := s.Config.getTopic(event)
+
+	sub, err := s.openSubscription(ctx, topic)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	s.subs = append(s.subs, sub)
+	s.mu.Unlock()
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.receive(ctx, topic, sub, handler)
+	}()
+
+	return nil
+}
+
+// Close stop all subscriptions and wait for running handlers
+func (s *Subscriber) Close(ctx context.Context) error {
+	s.mu.Lock()
+	subs := s.subs
+	s.subs = nil
+	select {
+	case <-s.done:
+	default:
+		// stop handlers retrying in place
+		close(s.done)
+	}
+	s.mu.Unlock()
+
+	var rerr error
+	for _, sub := range subs {
+		if err := sub.Shutdown(ctx); err != nil {
+			rerr = err
+		}
+	}
+
+	s.wg.Wait()
+
+	if err := s.Config.Encryption.closeKeeper(); err != nil {
+		rerr = err
+	}
+	if err := s.Config.ClaimCheck.closeBucket(); err != nil {
+		rerr = err
+	}
+	return rerr
+}
+
+func (s *Subscriber) openSubscription(ctx context.Context, topic string) (*pubsub.Subscription, error) {
+	to := strings.ReplaceAll(s.PubsubURL, "$TOPIC", topic)
+
+	u, err := url.Parse(to)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme != "kafka" {
+		return pubsub.OpenSubscription(ctx, to)
+	}
+
+	brokers := s.KafkaBroker
+	if strings.Contains(u.Host, ":") {
+		brokers = u.Host
+	}
+
+	if brokers == "" {
+		return nil, errors.New("missing kafka broker")
+	}
+
+	if s.KafkaGroup == "" {
+		return nil, errors.New("missing kafka_group param")
+	}
+
+	return kafkapubsub.OpenSubscription(strings.Split(brokers, ","), s.kafka, s.KafkaGroup, []string{topic}, &kafkapubsub.SubscriptionOptions{KeyName: kafkaKeyName})
+}
+
+func (s *Subscriber) receive(ctx context.Context, topic string, sub *pubsub.Subscription, handler Handler) {
+	log := logger.GetLoggerContext(ctx, "event", "subscriberReceive").WithField("topic", topic)
+
+	sem := make(chan struct{}, s.MaxHandlers)
+	var wg sync.WaitGroup
+	defer wg.Wait()
+
+	for {
+		m, err := sub.Receive(ctx)
+		if err != nil {
+			if ctx.Err() == nil {
+				log.WithError(err).Debug("Subscription stopped")
+			}
+			return
+		}
+
+		sem <- struct{}{}
+		slot := &handlerSlot{sem: sem, held: true}
+		wg.Add(1)
+		go func() {
+			defer func() {
+				slot.release()
+				wg.Done()
+			}()
+			s.handle(context.WithValue(ctx, handlerSlotKey{}, slot), topic, m, handler)
+		}()
+	}
+}
+
+type handlerSlotKey struct{}
+
+// handlerSlot max_handlers slot held by handler, handler waiting for another message such as in sequencer releases
+// it meanwhile so the message it waits for can be received. Used only by goroutine of its handler
+type handlerSlot struct {
+	sem  chan struct{}
+	held bool
+}
+
+// slotOf handler slot of context, nil when handler is not run by subscriber
+func slotOf(ctx context.Context) *handlerSlot {
+	slot, _ := ctx.Value(handlerSlotKey{}).(*handlerSlot)
+	return slot
+}
+
+func (h *handlerSlot) release() {
+	if h == nil || !h.held {
+		return
+	}
+	<-h.sem
+	h.held = false
+}
+
+// acquire take slot back, waiting for a free one
+func (h *handlerSlot) acquire(ctx context.Context) error {
+	if h == nil || h.held {
+		return nil
+	}
+
+	select {
+	case h.sem <- struct{}{}:
+		h.held = true
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s *Subscriber) handle(ctx context.Context, topic string, m *pubsub.Message, handler Handler) {
+	log := logger.GetLoggerContext(ctx, "event", "subscriberHandle")
+
+	body := m.Body
+	if m.Metadata[claimCheckHeader] != "" {
+		err := s.attempt(ctx, m, func() error {
+			var err error
+			body, err = s.Config.ClaimCheck.fetch(ctx, m.Body)
+			return err
+		})
+		if err != nil {
+			log.WithError(err).WithField("claim_check", m.Metadata[claimCheckHeader]).Warn("Error fetching event")
+			s.reject(ctx, m)
+			return
+		}
+	}
+
+	codec, msg, err := s.Config.decode(topic, m.Metadata, body)
+	if err != nil {
+		// malformed message will never be processed, drop it
+		log.WithError(err).WithField("message", string(m.Body)).Error("Error decoding event")
+		m.Ack()
+		return
+	}
+
+	if msg.Metadata == nil {
+		msg.Metadata = make(map[string]interface{})
+	}
+
+	if _, ok := msg.Metadata["key"]; !ok && m.Metadata["key"] != "" {
+		msg.Metadata["key"] = m.Metadata["key"]
+	}
+
+	headers := m.Metadata
+	if tp, ok := msg.Metadata["traceparent"].(string); ok && headers["traceparent"] == "" {
+		headers = map[string]string{"traceparent": tp}
+	}
+
+	ctx, span := startSpan(extractContext(ctx, headers), topic, m.Metadata["key"], trace.SpanKindConsumer)
+	defer span.End()
+
+	// decryption may fail on keeper outage, so it is retried like handler error
+	decrypted := false
+	err = s.attempt(ctx, m, func() error {
+		if !decrypted {
+			if err := s.Config.Encryption.decryptMessage(ctx, codec, msg); err != nil {
+				return err
+			}
+			decrypted = true
+		}
+		return handler(ctx, msg)
+	})
+	endSpan(span, err)
+
+	if err != nil {
+		log.WithError(err).WithField("event", msg.Metadata["event"]).Warn("Error handling event")
+		s.reject(ctx, m)
+		return
+	}
+
+	m.Ack()
+}
+
+// attempt call fn once when message can be nacked, broker redeliver it after nack. Message that can not be nacked
+// would be lost once a later message is acked past its offset, so fn is retried in place with handler retry backoff
+func (s *Subscriber) attempt(ctx context.Context, m *pubsub.Message, fn func() error) error {
+	err := fn()
+	if err == nil || m.Nackable() {
+		return err
+	}
+
+	max := s.HandlerRetry.MaxAttempts
+	for n := 1; max <= 0 || n < max; n++ {
+		select {
+		case <-ctx.Done():
+			return err
+		case <-s.done:
+			return err
+		case <-time.After(s.HandlerRetry.backoff(n)):
+		}
+
+		if err = fn(); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// reject nack failed message. Message that can not be nacked has run out of attempts and is acked as dropped,
+// unless subscriber is stopping, then it is left unacked for the next consumer
+func (s *Subscriber) reject(ctx context.Context, m *pubsub.Message) {
+	if m.Nackable() {
+		m.Nack()
+		return
+	}
+
+	select {
+	case <-s.done:
+		return
+	default:
+	}
+	if ctx.Err() != nil {
+		return
+	}
+
+	logger.GetLoggerContext(ctx, "event", "subscriberReject").WithField("attempts", s.HandlerRetry.MaxAttempts).
+		Error("Dropping event that ran out of attempts")
+	m.Ack()
+}
+
+// decode decompress and decode message body according to its content encoding and content type
+func (c *EventConfig) decode(topic string, headers map[string]string, body []byte) (Codec, *EventMessage, error) {
+	codec, err := c.contentCodec(topic, headers[contentTypeHeader])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	body, err = decompress(headers[contentEncodingHeader], body)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var msg EventMessage
+	if err := codec.Unmarshal(body, &msg); err != nil {
+		return nil, nil, err
+	}
+	return codec, &msg, nil
+}
@@ -0,0 +1,120 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sahalazain/go-common/config"
+	_ "github.com/sahalazain/simplecache/mem"
+	"github.com/stretchr/testify/assert"
+	"gocloud.dev/pubsub"
+	_ "gocloud.dev/pubsub/mempubsub"
+)
+
+type testPerson struct {
+	Name    string `json:"name"`
+	Address string `json:"address"`
+}
+
+func TestConsumer(t *testing.T) {
+	cfg := map[string]interface{}{
+		"cache_url":  "mem://cc",
+		"pubsub_url": "mem://$TOPIC",
+	}
+	conf, err := config.Load(cfg, "")
+	assert.Nil(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err = pubsub.OpenTopic(ctx, "mem://consumer")
+	assert.Nil(t, err)
+
+	ps, err := NewPubSubEmitter(ctx, conf)
+	assert.Nil(t, err)
+
+	cons, err := NewConsumer(ctx, conf)
+	assert.Nil(t, err)
+	assert.NotNil(t, cons)
+
+	received := make(chan testPerson, 1)
+	fail := true
+	err = cons.Subscribe(ctx, "consumer", func(ctx context.Context, msg *EventMessage) error {
+		if fail {
+			fail = false
+			return errors.New("retry please")
+		}
+		var p testPerson
+		if err := msg.Decode(&p); err != nil {
+			return err
+		}
+		assert.Equal(t, "consumer", msg.Metadata["event"])
+		received <- p
+		return nil
+	})
+	assert.Nil(t, err)
+
+	err = ps.Publish(ctx, "consumer", testPerson{Name: "SiCepat", Address: "Jakarta"}, nil)
+	assert.Nil(t, err)
+
+	select {
+	case p := <-received:
+		assert.Equal(t, "SiCepat", p.Name)
+		assert.Equal(t, "Jakarta", p.Address)
+	case <-ctx.Done():
+		t.Fatal("event not received")
+	}
+
+	assert.Nil(t, cons.Close(ctx))
+}
+
+func TestConsumerRetryInPlace(t *testing.T) {
+	cfg := map[string]interface{}{
+		"pubsub_url":    "mem://$TOPIC",
+		"handler_retry": map[string]interface{}{"initial_backoff": "1ms", "max_backoff": "5ms"},
+	}
+	conf, err := config.Load(cfg, "")
+	assert.Nil(t, err)
+
+	ctx := context.Background()
+
+	sub, err := NewSubscriber(ctx, conf)
+	assert.Nil(t, err)
+
+	// message of driver that can not nack, such as kafka
+	m := &pubsub.Message{}
+	assert.False(t, m.Nackable())
+
+	calls := 0
+	err = sub.attempt(ctx, m, func() error {
+		calls++
+		if calls < 4 {
+			return errors.New("not yet")
+		}
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 4, calls)
+
+	sub.HandlerRetry.MaxAttempts = 2
+	calls = 0
+	err = sub.attempt(ctx, m, func() error {
+		calls++
+		return errors.New("always")
+	})
+	assert.NotNil(t, err)
+	assert.Equal(t, 2, calls)
+
+	// closing subscriber stop retrying without attempts limit
+	sub.HandlerRetry.MaxAttempts = 0
+	assert.Nil(t, sub.Close(ctx))
+	calls = 0
+	err = sub.attempt(ctx, m, func() error {
+		calls++
+		return errors.New("always")
+	})
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, calls)
+}
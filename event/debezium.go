@@ -0,0 +1,87 @@
+package event
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+const (
+	layoutDefault  = "default"
+	layoutDebezium = "debezium"
+)
+
+// DebeziumRecord outbox record in layout of Debezium outbox event router, so stock EventRouter SMT can route it.
+// Topic is stored as aggregate type, key as aggregate ID and event name as type.
+// Payload is event message as native document, binary payload is kept as base64 string
+type DebeziumRecord struct {
+	ID            string            `json:"_id,omitempty" mapstructure:"_id" docstore:"_id"`
+	AggregateType string            `json:"aggregatetype,omitempty" mapstructure:"aggregatetype" docstore:"aggregatetype"`
+	AggregateID   string            `json:"aggregateid,omitempty" mapstructure:"aggregateid" docstore:"aggregateid"`
+	Type          string            `json:"type,omitempty" mapstructure:"type" docstore:"type"`
+	Payload       interface{}       `json:"payload,omitempty" mapstructure:"payload" docstore:"payload"`
+	Timestamp     time.Time         `json:"timestamp,omitempty" mapstructure:"timestamp" docstore:"timestamp"`
+	Headers       map[string]string `json:"headers,omitempty" mapstructure:"headers" docstore:"headers"`
+	ValueEncoding string            `json:"value_encoding,omitempty" mapstructure:"value_encoding" docstore:"value_encoding"`
+}
+
+// newDebeziumRecord build debezium record of envelope from its outbox record
+func newDebeziumRecord(env *Envelope, o *OutboxRecord) (*DebeziumRecord, error) {
+	d := &DebeziumRecord{
+		ID:            o.ID,
+		AggregateType: o.KafkaTopic,
+		AggregateID:   o.KafkaKey,
+		Type:          env.Event,
+		Headers:       o.Headers,
+		ValueEncoding: o.ValueEncoding,
+	}
+
+	if o.ValueEncoding != "" {
+		d.Payload = o.KafkaValue
+		return d, nil
+	}
+
+	var payload map[string]interface{}
+	if err := json.Unmarshal([]byte(o.KafkaValue), &payload); err != nil {
+		return nil, err
+	}
+	d.Payload = payload
+	return d, nil
+}
+
+func (d *DebeziumRecord) documentID() string {
+	return d.ID
+}
+
+func (d *DebeziumRecord) createdAt() time.Time {
+	return d.Timestamp
+}
+
+func (d *DebeziumRecord) setCreatedAt(t time.Time) {
+	d.Timestamp = t
+}
+
+func validLayout(layout string) error {
+	switch layout {
+	case "", layoutDefault, layoutDebezium:
+		return nil
+	}
+	return fmt.Errorf("[Outbox] unsupported record layout %q", layout)
+}
+
+// documents outbox documents of records in configured layout, idx is envelope index of each record
+func (o *Outbox) documents(envs []*Envelope, records []*OutboxRecord, idx []int) ([]outboxDocument, error) {
+	if o.RecordLayout != layoutDebezium {
+		return documents(records), nil
+	}
+
+	docs := make([]outboxDocument, len(records))
+	for j, rec := range records {
+		d, err := newDebeziumRecord(envs[idx[j]], rec)
+		if err != nil {
+			return nil, err
+		}
+		docs[j] = d
+	}
+	return docs, nil
+}
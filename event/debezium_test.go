@@ -0,0 +1,63 @@
+package event
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sahalazain/go-common/config"
+	_ "github.com/sahalazain/simplecache/mem"
+	"github.com/stretchr/testify/assert"
+	"gocloud.dev/docstore"
+	_ "gocloud.dev/docstore/memdocstore"
+)
+
+func TestOutboxDebeziumLayout(t *testing.T) {
+	cfg := map[string]interface{}{
+		"collection_url": "mem://debezium/_id",
+		"cache_url":      "mem://dbc",
+		"record_layout":  "debezium",
+		"config": map[string]interface{}{
+			"event_map": map[string]interface{}{"parcel_created": "parcel"},
+		},
+	}
+	conf, err := config.Load(cfg, "")
+	assert.Nil(t, err)
+
+	ctx := context.Background()
+
+	out, err := NewOutboxEmitter(ctx, conf)
+	assert.Nil(t, err)
+
+	msg := map[string]interface{}{"parcel": "P1", "weight": 2}
+	assert.Nil(t, out.Push(ctx, "parcel_created", "P1", msg, nil))
+
+	col, err := docstore.OpenCollection(ctx, "mem://debezium/_id")
+	assert.Nil(t, err)
+
+	iter := col.Query().Get(ctx)
+	defer iter.Stop()
+
+	var d DebeziumRecord
+	assert.Nil(t, iter.Next(ctx, &d))
+	assert.Equal(t, "parcel", d.AggregateType)
+	assert.Equal(t, "P1", d.AggregateID)
+	assert.Equal(t, "parcel_created", d.Type)
+	assert.False(t, d.Timestamp.IsZero())
+	assert.Equal(t, "application/json", d.Headers[contentTypeHeader])
+
+	payload, ok := d.Payload.(map[string]interface{})
+	assert.True(t, ok)
+	data, ok := payload["data"].(map[string]interface{})
+	assert.True(t, ok)
+	assert.Equal(t, "P1", data["parcel"])
+
+	var next DebeziumRecord
+	assert.NotNil(t, iter.Next(ctx, &next))
+
+	cfg["record_layout"] = "avro"
+	conf, err = config.Load(cfg, "")
+	assert.Nil(t, err)
+
+	_, err = NewOutboxEmitter(ctx, conf)
+	assert.NotNil(t, err)
+}
@@ -8,15 +8,27 @@ import (
 	"errors"
 	"net/url"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/sahalazain/go-common/config"
 	"github.com/sahalazain/simplecache"
+	"google.golang.org/protobuf/proto"
 )
 
+// ErrEmitterClosed returned when sending through closed emitter
+var ErrEmitterClosed = errors.New("[Emitter] emitter is closed")
+
 //Emitter event emitter
 type Emitter interface {
 	Publish(ctx context.Context, event string, message interface{}, metadata map[string]interface{}) error
 	Push(ctx context.Context, event, key string, message interface{}, metadata map[string]interface{}) error
+	// PublishBatch publish multiple events at once, failed entries are reported as BatchError
+	PublishBatch(ctx context.Context, events []Event) error
+	// Flush wait until all accepted events are delivered
+	Flush(ctx context.Context) error
+	// Close flush pending events and release emitter resources
+	Close(ctx context.Context) error
 }
 
 // EventMessage event message
@@ -29,19 +41,34 @@ func (m *EventMessage) ToBytes() ([]byte, error) {
 	return json.Marshal(m)
 }
 
+// Decode decode event data into out, raw protobuf data is decoded into out proto.Message
+func (m *EventMessage) Decode(out interface{}) error {
+	if raw, ok := m.Data.([]byte); ok {
+		if pm, ok := out.(proto.Message); ok {
+			return proto.Unmarshal(raw, pm)
+		}
+	}
+
+	b, err := json.Marshal(m.Data)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, out)
+}
+
 //NewEmitter create event emitter instance
-func NewEmitter(ctx context.Context, conf config.Getter) (Emitter, error) {
+func NewEmitter(ctx context.Context, conf config.Getter, opts ...Option) (Emitter, error) {
 	if conf == nil {
 		return nil, errors.New("[Emitter] missing event_emitter param")
 	}
 
 	switch strings.ToLower(conf.GetString("type")) {
 	case "pubsub":
-		return NewPubSubEmitter(ctx, conf)
+		return NewPubSubEmitter(ctx, conf, opts...)
 	case "outbox":
-		return NewOutboxEmitter(ctx, conf)
+		return NewOutboxEmitter(ctx, conf, opts...)
 	case "hybrid":
-		return NewHybridEmitter(ctx, conf)
+		return NewHybridEmitter(ctx, conf, opts...)
 	default:
 		return nil, errors.New("[Emitter] unsupported emitter")
 	}
@@ -69,26 +96,35 @@ func NewEmitterCache(URL string) (*EmitterCache, error) {
 type EmitterCache struct {
 	cache   simplecache.Cache
 	keyName string
+	lmu     sync.Mutex
+	locks   map[string]*keyLock
 }
 
 func (e *EmitterCache) getPrevious(ctx context.Context, event string) string {
-	if e.cache == nil {
-		return ""
-	}
-	k, _ := e.cache.GetString(ctx, e.keyName+event)
-	return k
+	return e.getChain(ctx, event).Hash
 }
 
-func (e *EmitterCache) setCurrent(ctx context.Context, event, val string) error {
+// Close close underlying cache
+func (e *EmitterCache) Close() error {
 	if e.cache == nil {
-		return errors.New("[Emitter] empty cache")
+		return nil
 	}
-	return e.cache.Set(ctx, e.keyName+event, val, 0)
+	return e.cache.Close()
 }
 
 type EventConfig struct {
 	Metadata map[string]map[string]interface{} `json:"metadata,omitempty" mapstructure:"metadata"`
 	EventMap map[string]string                 `json:"event_map,omitempty" mapstructure:"event_map"`
+	Retry    RetryPolicy                       `json:"retry,omitempty" mapstructure:"retry"`
+	Headers  HeaderConfig                      `json:"headers,omitempty" mapstructure:"headers"`
+	// Codecs payload codec name per topic, "default" apply to the rest
+	Codecs      map[string]string `json:"codecs,omitempty" mapstructure:"codecs"`
+	SchemaDir   string            `json:"schema_dir,omitempty" mapstructure:"schema_dir"`
+	Compression CompressionConfig `json:"compression,omitempty" mapstructure:"compression"`
+	Encryption  EncryptionConfig  `json:"encryption,omitempty" mapstructure:"encryption"`
+	ClaimCheck  ClaimCheckConfig  `json:"claim_check,omitempty" mapstructure:"claim_check"`
+	// DedupeWindow how long idempotency keys of delivered events are remembered
+	DedupeWindow time.Duration `json:"dedupe_window,omitempty" mapstructure:"dedupe_window"`
 }
 
 func (c *EventConfig) getTopic(event string) string {
@@ -98,18 +134,21 @@ func (c *EventConfig) getTopic(event string) string {
 	return event
 }
 
+// getMetadata return copy of configured event metadata, safe to be modified by caller
 func (c *EventConfig) getMetadata(event string) map[string]interface{} {
 	if m, ok := c.Metadata[event]; ok {
-		m["event"] = event
-		return m
+		md := copyMetadata(m)
+		md["event"] = event
+		return md
 	}
 	return c.getDefaultMetadata(event)
 }
 
 func (c *EventConfig) getDefaultMetadata(event string) map[string]interface{} {
 	if m, ok := c.Metadata["default"]; ok {
-		m["event"] = event
-		return m
+		md := copyMetadata(m)
+		md["event"] = event
+		return md
 	}
 
 	return map[string]interface{}{
@@ -118,8 +157,24 @@ func (c *EventConfig) getDefaultMetadata(event string) map[string]interface{} {
 	}
 }
 
+func copyMetadata(m map[string]interface{}) map[string]interface{} {
+	md := make(map[string]interface{}, len(m)+4)
+	for k, v := range m {
+		if n, ok := v.(map[string]interface{}); ok {
+			v = copyMetadata(n)
+		}
+		md[k] = v
+	}
+	return md
+}
+
 func hash(m interface{}) (string, error) {
-	mb, err := json.Marshal(m)
+	return hashCodec(jsonCodec{}, m)
+}
+
+// hashCodec hash message encoded by codec
+func hashCodec(c Codec, m interface{}) (string, error) {
+	mb, err := c.Marshal(m)
 	if err != nil {
 		return "", err
 	}
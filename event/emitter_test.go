@@ -0,0 +1,31 @@
+package event
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEventConfigMetadataCopy(t *testing.T) {
+	c := &EventConfig{
+		Metadata: map[string]map[string]interface{}{
+			"default": {"version": 2},
+			"created": {"version": 3, "source": map[string]interface{}{"app": "tracking"}},
+		},
+	}
+
+	md := c.getMetadata("created")
+	md["hash"] = "abc"
+	md["source"].(map[string]interface{})["app"] = "changed"
+
+	assert.Equal(t, 3, md["version"])
+	assert.Equal(t, "created", md["event"])
+	assert.NotContains(t, c.Metadata["created"], "hash")
+	assert.NotContains(t, c.Metadata["created"], "event")
+	assert.Equal(t, "tracking", c.Metadata["created"]["source"].(map[string]interface{})["app"])
+
+	md = c.getMetadata("updated")
+	assert.Equal(t, 2, md["version"])
+	assert.Equal(t, "updated", md["event"])
+	assert.NotContains(t, c.Metadata["default"], "event")
+}
@@ -0,0 +1,215 @@
+package event
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"io"
+
+	"gocloud.dev/secrets"
+)
+
+const (
+	encryptionMetadata  = "encryption"
+	encryptionAlgorithm = "AES-GCM"
+	dataKeySize         = 32
+)
+
+// EncryptionConfig envelope encryption of event data. Data of each event is sealed with fresh AES-GCM data key,
+// the data key is wrapped by secrets keeper and carried in event metadata, metadata itself stays readable.
+// Data is compressed before sealing and hash of encrypted event is random, so neither reveals the data
+type EncryptionConfig struct {
+	KeeperURL string `json:"keeper_url,omitempty" mapstructure:"keeper_url"`
+	// Events topics to encrypt, all topics when empty
+	Events []string `json:"events,omitempty" mapstructure:"events"`
+	keeper *secrets.Keeper
+}
+
+// openKeeper open configured secrets keeper
+func (e *EncryptionConfig) openKeeper(ctx context.Context) error {
+	if e.KeeperURL == "" || e.keeper != nil {
+		return nil
+	}
+
+	k, err := secrets.OpenKeeper(ctx, e.KeeperURL)
+	if err != nil {
+		return err
+	}
+	e.keeper = k
+	return nil
+}
+
+// closeKeeper close opened secrets keeper
+func (e *EncryptionConfig) closeKeeper() error {
+	if e.keeper == nil {
+		return nil
+	}
+	return e.keeper.Close()
+}
+
+// enabled whether data of topic is encrypted
+func (e *EncryptionConfig) enabled(topic string) bool {
+	if e.keeper == nil {
+		return false
+	}
+	if len(e.Events) == 0 {
+		return true
+	}
+	for _, t := range e.Events {
+		if t == topic {
+			return true
+		}
+	}
+	return false
+}
+
+// seal encrypt plain data with new data key, returns nonce prefixed ciphertext and wrapped data key
+func (e *EncryptionConfig) seal(ctx context.Context, plain []byte) ([]byte, string, error) {
+	key := make([]byte, dataKeySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return nil, "", err
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return nil, "", err
+	}
+
+	wrapped, err := e.keeper.Encrypt(ctx, key)
+	if err != nil {
+		return nil, "", err
+	}
+
+	return gcm.Seal(nonce, nonce, plain, nil), base64.StdEncoding.EncodeToString(wrapped), nil
+}
+
+// unseal decrypt sealed data using wrapped data key
+func (e *EncryptionConfig) unseal(ctx context.Context, sealed []byte, wrapped string) ([]byte, error) {
+	wk, err := base64.StdEncoding.DecodeString(wrapped)
+	if err != nil {
+		return nil, err
+	}
+
+	key, err := e.keeper.Decrypt(ctx, wk)
+	if err != nil {
+		return nil, err
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("[Encryption] invalid sealed data")
+	}
+
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, nil)
+}
+
+// opaqueHash random hash of encrypted event, unique like hash of its data without revealing it
+func opaqueHash() (string, error) {
+	b := make([]byte, sha256.Size)
+	if _, err := io.ReadFull(rand.Reader, b); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(b), nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// encryptMessage seal data of encoded message, data is encoded by codec before encryption. Sealed data does not
+// compress, so data of at least min size is compressed with encoding before being sealed
+func (e *EncryptionConfig) encryptMessage(ctx context.Context, codec Codec, msg *EventMessage, encoding string, minSize int) (*EventMessage, error) {
+	plain, err := codec.Marshal(msg.Data)
+	if err != nil {
+		return nil, err
+	}
+
+	enc := map[string]interface{}{
+		"algorithm": encryptionAlgorithm,
+	}
+	if encoding != "" && len(plain) >= minSize {
+		if plain, err = compress(encoding, plain); err != nil {
+			return nil, err
+		}
+		enc["encoding"] = encoding
+	}
+
+	sealed, wrapped, err := e.seal(ctx, plain)
+	if err != nil {
+		return nil, err
+	}
+
+	md := make(map[string]interface{}, len(msg.Metadata)+1)
+	for k, v := range msg.Metadata {
+		md[k] = v
+	}
+	enc["key"] = wrapped
+	md[encryptionMetadata] = enc
+
+	return &EventMessage{Data: sealed, Metadata: md}, nil
+}
+
+// decryptMessage open sealed data of decoded message. Message without encryption metadata,
+// or received without configured keeper, is left as is
+func (e *EncryptionConfig) decryptMessage(ctx context.Context, codec Codec, msg *EventMessage) error {
+	enc, ok := msg.Metadata[encryptionMetadata].(map[string]interface{})
+	if !ok || e.keeper == nil {
+		return nil
+	}
+
+	if alg, _ := enc["algorithm"].(string); alg != encryptionAlgorithm {
+		return errors.New("[Encryption] unsupported algorithm")
+	}
+
+	wrapped, _ := enc["key"].(string)
+
+	var sealed []byte
+	switch d := msg.Data.(type) {
+	case []byte:
+		sealed = d
+	case string:
+		b, err := base64.StdEncoding.DecodeString(d)
+		if err != nil {
+			return err
+		}
+		sealed = b
+	default:
+		return errors.New("[Encryption] invalid sealed data")
+	}
+
+	plain, err := e.unseal(ctx, sealed, wrapped)
+	if err != nil {
+		return err
+	}
+
+	if encoding, _ := enc["encoding"].(string); encoding != "" {
+		if plain, err = decompress(encoding, plain); err != nil {
+			return err
+		}
+	}
+
+	msg.Data = nil
+	if err := codec.Unmarshal(plain, &msg.Data); err != nil {
+		return err
+	}
+	delete(msg.Metadata, encryptionMetadata)
+	return nil
+}
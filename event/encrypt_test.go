@@ -0,0 +1,160 @@
+package event
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sahalazain/go-common/config"
+	_ "github.com/sahalazain/simplecache/mem"
+	"github.com/stretchr/testify/assert"
+	"gocloud.dev/docstore"
+	_ "gocloud.dev/docstore/memdocstore"
+	"gocloud.dev/pubsub"
+	_ "gocloud.dev/pubsub/mempubsub"
+	_ "gocloud.dev/secrets/localsecrets"
+)
+
+const testKeeperURL = "base64key://smGbjm71Nxd1Ig5FS0wj9SlbzAIrnolCz9bQQ6uAhl4="
+
+func TestEncryptionSeal(t *testing.T) {
+	ctx := context.Background()
+
+	e := &EncryptionConfig{KeeperURL: testKeeperURL, Events: []string{"customer"}}
+	assert.False(t, e.enabled("customer"))
+	assert.Nil(t, e.openKeeper(ctx))
+	defer e.closeKeeper()
+
+	assert.True(t, e.enabled("customer"))
+	assert.False(t, e.enabled("manifest"))
+
+	sealed, wrapped, err := e.seal(ctx, []byte("Jakarta"))
+	assert.Nil(t, err)
+	assert.NotContains(t, string(sealed), "Jakarta")
+
+	plain, err := e.unseal(ctx, sealed, wrapped)
+	assert.Nil(t, err)
+	assert.Equal(t, "Jakarta", string(plain))
+
+	sealed[len(sealed)-1] ^= 1
+	_, err = e.unseal(ctx, sealed, wrapped)
+	assert.NotNil(t, err)
+}
+
+func TestEncryptionCompression(t *testing.T) {
+	ctx := context.Background()
+
+	e := &EncryptionConfig{KeeperURL: testKeeperURL}
+	assert.Nil(t, e.openKeeper(ctx))
+	defer e.closeKeeper()
+
+	data := map[string]interface{}{"note": strings.Repeat("parcel received at Jakarta hub ", 100)}
+	plain, err := jsonCodec{}.Marshal(data)
+	assert.Nil(t, err)
+
+	// data is compressed before it is sealed
+	msg, err := e.encryptMessage(ctx, jsonCodec{}, &EventMessage{Data: data, Metadata: map[string]interface{}{}}, "gzip", 100)
+	assert.Nil(t, err)
+	assert.Equal(t, "gzip", msg.Metadata[encryptionMetadata].(map[string]interface{})["encoding"])
+	assert.True(t, len(msg.Data.([]byte)) < len(plain)/4)
+
+	b, err := jsonCodec{}.Marshal(msg)
+	assert.Nil(t, err)
+	var got EventMessage
+	assert.Nil(t, jsonCodec{}.Unmarshal(b, &got))
+	assert.Nil(t, e.decryptMessage(ctx, jsonCodec{}, &got))
+	assert.Equal(t, data, got.Data)
+
+	// small data is sealed as is
+	msg, err = e.encryptMessage(ctx, jsonCodec{}, &EventMessage{Data: "P1", Metadata: map[string]interface{}{}}, "gzip", 100)
+	assert.Nil(t, err)
+	assert.NotContains(t, msg.Metadata[encryptionMetadata], "encoding")
+
+	// hash of encrypted event does not reveal its data
+	c := &EventConfig{Encryption: *e}
+	ec, err := NewEmitterCache("mem://ehc")
+	assert.Nil(t, err)
+
+	envs, berr, unlock := c.resolve(ctx, ec, []Event{{Name: "customer", Message: "Jakarta"}, {Name: "customer", Message: "Jakarta"}})
+	unlock()
+	assert.Len(t, berr, 0)
+
+	h, err := hash("Jakarta")
+	assert.Nil(t, err)
+	assert.NotEqual(t, h, envs[0].Metadata["hash"])
+	assert.NotEqual(t, envs[0].Hash, envs[1].Hash)
+}
+
+func TestOutboxEncryption(t *testing.T) {
+	cfg := map[string]interface{}{
+		"collection_url": "mem://encrypted/_id",
+		"cache_url":      "mem://enc",
+		"pubsub_url":     "mem://$TOPIC",
+		"config": map[string]interface{}{
+			"encryption": map[string]interface{}{
+				"keeper_url": testKeeperURL,
+			},
+		},
+	}
+	conf, err := config.Load(cfg, "")
+	assert.Nil(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err = pubsub.OpenTopic(ctx, "mem://customer")
+	assert.Nil(t, err)
+
+	out, err := NewOutboxEmitter(ctx, conf)
+	assert.Nil(t, err)
+
+	relay, err := NewOutboxRelay(ctx, conf)
+	assert.Nil(t, err)
+
+	sub, err := NewSubscriber(ctx, conf)
+	assert.Nil(t, err)
+
+	received := make(chan testPerson, 1)
+	err = sub.Subscribe(ctx, "customer", func(ctx context.Context, msg *EventMessage) error {
+		_, ok := msg.Metadata[encryptionMetadata]
+		assert.False(t, ok)
+
+		var p testPerson
+		if err := msg.Decode(&p); err != nil {
+			return err
+		}
+		received <- p
+		return nil
+	})
+	assert.Nil(t, err)
+
+	assert.Nil(t, out.Publish(ctx, "customer", testPerson{Name: "SiCepat", Address: "Jakarta"}, nil))
+
+	col, err := docstore.OpenCollection(ctx, "mem://encrypted/_id")
+	assert.Nil(t, err)
+
+	var o OutboxRecord
+	iter := col.Query().Get(ctx)
+	assert.Nil(t, iter.Next(ctx, &o))
+	iter.Stop()
+
+	body, err := o.value()
+	assert.Nil(t, err)
+	assert.False(t, strings.Contains(string(body), "Jakarta"))
+	assert.True(t, strings.Contains(string(body), `"event":"customer"`))
+
+	n, err := relay.Relay(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+
+	select {
+	case p := <-received:
+		assert.Equal(t, "Jakarta", p.Address)
+	case <-ctx.Done():
+		t.Fatal("event not received")
+	}
+
+	assert.Nil(t, sub.Close(ctx))
+	assert.Nil(t, out.Close(ctx))
+}
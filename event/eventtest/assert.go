@@ -0,0 +1,129 @@
+package eventtest
+
+import (
+	"fmt"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// Matcher match recorded event
+type Matcher func(e Recorded) bool
+
+// Message match event with message equal to v
+func Message(v interface{}) Matcher {
+	return func(e Recorded) bool {
+		return assert.ObjectsAreEqual(v, e.Message)
+	}
+}
+
+// Key match event pushed with key
+func Key(key string) Matcher {
+	return func(e Recorded) bool {
+		return e.Key == key
+	}
+}
+
+// Metadata match event with merged metadata entry k equal to v
+func Metadata(k string, v interface{}) Matcher {
+	return func(e Recorded) bool {
+		mv, ok := e.Metadata[k]
+		return ok && assert.ObjectsAreEqual(v, mv)
+	}
+}
+
+// All match event matching every matcher
+func All(matchers ...Matcher) Matcher {
+	return func(e Recorded) bool {
+		for _, m := range matchers {
+			if m != nil && !m(e) {
+				return false
+			}
+		}
+		return true
+	}
+}
+
+// AssertPublished assert event was successfully sent at least once matching matcher, nil matcher match any event
+func (r *Recorder) AssertPublished(t assert.TestingT, event string, matcher Matcher, msgAndArgs ...interface{}) bool {
+	if h, ok := t.(interface{ Helper() }); ok {
+		h.Helper()
+	}
+
+	events := r.Published(event)
+	for _, e := range events {
+		if matcher == nil || matcher(e) {
+			return true
+		}
+	}
+
+	return assert.Fail(t, fmt.Sprintf("event %q matching was not published, published: %s", event, describe(events)), msgAndArgs...)
+}
+
+// AssertNotPublished assert no event matching matcher was successfully sent, nil matcher match any event
+func (r *Recorder) AssertNotPublished(t assert.TestingT, event string, matcher Matcher, msgAndArgs ...interface{}) bool {
+	if h, ok := t.(interface{ Helper() }); ok {
+		h.Helper()
+	}
+
+	for _, e := range r.Published(event) {
+		if matcher == nil || matcher(e) {
+			return assert.Fail(t, fmt.Sprintf("event %q was published: %s", event, describe([]Recorded{e})), msgAndArgs...)
+		}
+	}
+	return true
+}
+
+// AssertSequence assert events pushed with key were successfully sent in order matching matchers one by one,
+// chained by previous hash with increasing sequence
+func (r *Recorder) AssertSequence(t assert.TestingT, event, key string, matchers ...Matcher) bool {
+	if h, ok := t.(interface{ Helper() }); ok {
+		h.Helper()
+	}
+
+	events := make([]Recorded, 0)
+	for _, e := range r.Published(event) {
+		if e.Key == key && e.Sequence > 0 {
+			events = append(events, e)
+		}
+	}
+
+	if len(events) != len(matchers) {
+		return assert.Fail(t, fmt.Sprintf("event %q of key %q pushed %d times, expected %d: %s",
+			event, key, len(events), len(matchers), describe(events)))
+	}
+
+	for i, e := range events {
+		if matchers[i] != nil && !matchers[i](e) {
+			return assert.Fail(t, fmt.Sprintf("event %q of key %q at position %d does not match: %s",
+				event, key, i, describe([]Recorded{e})))
+		}
+
+		if i == 0 {
+			continue
+		}
+
+		prev := events[i-1]
+		if e.Sequence != prev.Sequence+1 || e.Metadata["previous"] != prev.Metadata["hash"] {
+			return assert.Fail(t, fmt.Sprintf("event %q of key %q at position %d is not chained to its predecessor: %s",
+				event, key, i, describe([]Recorded{prev, e})))
+		}
+	}
+
+	return true
+}
+
+// describe print recorded events for failure message
+func describe(events []Recorded) string {
+	if len(events) == 0 {
+		return "none"
+	}
+
+	s := ""
+	for i, e := range events {
+		if i > 0 {
+			s += ", "
+		}
+		s += fmt.Sprintf("{topic: %s, key: %s, sequence: %d, message: %v, metadata: %v}", e.Topic, e.Key, e.Sequence, e.Message, e.Metadata)
+	}
+	return s
+}
@@ -0,0 +1,67 @@
+package eventtest
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeT test reporter recording failures
+type fakeT struct {
+	failures []string
+}
+
+func (t *fakeT) Errorf(format string, args ...interface{}) {
+	t.failures = append(t.failures, fmt.Sprintf(format, args...))
+}
+
+func TestAssertPublished(t *testing.T) {
+	r, err := NewRecorder(nil)
+	assert.Nil(t, err)
+
+	ctx := context.Background()
+	assert.Nil(t, r.Publish(ctx, "manifest", map[string]interface{}{"city": "Jakarta"}, map[string]interface{}{"source": "hub"}))
+
+	assert.True(t, r.AssertPublished(t, "manifest", nil))
+	assert.True(t, r.AssertPublished(t, "manifest", All(Message(map[string]interface{}{"city": "Jakarta"}), Metadata("source", "hub"))))
+	assert.True(t, r.AssertNotPublished(t, "parcel", nil))
+
+	ft := &fakeT{}
+	assert.False(t, r.AssertPublished(ft, "manifest", Message(map[string]interface{}{"city": "Bandung"})))
+	assert.False(t, r.AssertPublished(ft, "parcel", nil))
+	assert.False(t, r.AssertNotPublished(ft, "manifest", Metadata("source", "hub")))
+	assert.Len(t, ft.failures, 3)
+
+	// failed events are not published
+	r.Fail("parcel", fmt.Errorf("broker down"))
+	assert.NotNil(t, r.Push(ctx, "parcel", "P1", "picked", nil))
+	assert.False(t, r.AssertPublished(ft, "parcel", nil))
+}
+
+func TestAssertSequence(t *testing.T) {
+	r, err := NewRecorder(nil)
+	assert.Nil(t, err)
+
+	ctx := context.Background()
+	for _, status := range []string{"picked", "sorted", "delivered"} {
+		assert.Nil(t, r.Push(ctx, "parcel", "P1", status, nil))
+	}
+	assert.Nil(t, r.Push(ctx, "parcel", "P2", "picked", nil))
+	assert.Nil(t, r.Publish(ctx, "parcel", "unkeyed", nil))
+
+	assert.True(t, r.AssertSequence(t, "parcel", "P1", Message("picked"), Message("sorted"), nil))
+	assert.True(t, r.AssertSequence(t, "parcel", "P2", Message("picked")))
+
+	ft := &fakeT{}
+	assert.False(t, r.AssertSequence(ft, "parcel", "P1", Message("picked"), Message("sorted")))
+	assert.False(t, r.AssertSequence(ft, "parcel", "P1", Message("sorted"), Message("picked"), Message("delivered")))
+	assert.Len(t, ft.failures, 2)
+
+	// broken chain is reported
+	ft = &fakeT{}
+	r.events[1].Metadata["previous"] = "tampered"
+	assert.False(t, r.AssertSequence(ft, "parcel", "P1", nil, nil, nil))
+	assert.Len(t, ft.failures, 1)
+}
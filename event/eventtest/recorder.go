@@ -0,0 +1,137 @@
+// Package eventtest record events of an event.Emitter for unit tests, without broker or subscription
+package eventtest
+
+import (
+	"context"
+	"sync"
+
+	"github.com/sahalazain/go-common/config"
+	"github.com/sahalazain/go-common/event"
+	_ "github.com/sahalazain/simplecache/mem"
+)
+
+// Recorded event resolved by recorder, with error returned for it
+type Recorded struct {
+	Event string `json:"event,omitempty"`
+	Topic string `json:"topic,omitempty"`
+	// Key message key, hash of message for event published without key
+	Key      string                 `json:"key,omitempty"`
+	Sequence int64                  `json:"sequence,omitempty"`
+	Message  interface{}            `json:"message,omitempty"`
+	Metadata map[string]interface{} `json:"metadata,omitempty"`
+	Err      error                  `json:"-"`
+}
+
+// Recorder emitter recording every event it resolves instead of delivering it. Topic, metadata, chain and
+// idempotency are resolved the same way as pubsub emitter does
+type Recorder struct {
+	emitter  *event.PubSub
+	mu       sync.Mutex
+	events   []Recorded
+	failures map[string]error
+}
+
+// NewRecorder create recorder with emitter config, nil config record events with default event config
+// and keep chains in memory
+func NewRecorder(conf config.Getter, opts ...event.Option) (*Recorder, error) {
+	if conf == nil {
+		conf = config.NewEmbedConfig(map[string]interface{}{"cache_url": "mem://eventtest"})
+	}
+
+	r := &Recorder{failures: make(map[string]error)}
+	em, err := event.NewPubSubEmitter(context.Background(), conf, append(opts, event.WithSender(r.record))...)
+	if err != nil {
+		return nil, err
+	}
+	r.emitter = em
+
+	return r, nil
+}
+
+// Publish publish message
+func (r *Recorder) Publish(ctx context.Context, event string, message interface{}, metadata map[string]interface{}) error {
+	return r.emitter.Publish(ctx, event, message, metadata)
+}
+
+// Push publish sequential event
+func (r *Recorder) Push(ctx context.Context, event, key string, message interface{}, metadata map[string]interface{}) error {
+	return r.emitter.Push(ctx, event, key, message, metadata)
+}
+
+// PublishBatch publish multiple events, failed entries are reported as event.BatchError
+func (r *Recorder) PublishBatch(ctx context.Context, events []event.Event) error {
+	return r.emitter.PublishBatch(ctx, events)
+}
+
+// Flush events are recorded synchronously, nothing to flush
+func (r *Recorder) Flush(ctx context.Context) error {
+	return nil
+}
+
+// Close close recorder, recorded events are kept
+func (r *Recorder) Close(ctx context.Context) error {
+	return r.emitter.Close(ctx)
+}
+
+// Fail make events of topic fail with err, nil err make them succeed again
+func (r *Recorder) Fail(topic string, err error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err == nil {
+		delete(r.failures, topic)
+		return
+	}
+	r.failures[topic] = err
+}
+
+// Events return recorded events in order they were sent, including failed ones
+func (r *Recorder) Events() []Recorded {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	events := make([]Recorded, len(r.events))
+	copy(events, r.events)
+	return events
+}
+
+// Published return successfully sent events of event name
+func (r *Recorder) Published(name string) []Recorded {
+	events := make([]Recorded, 0)
+	for _, e := range r.Events() {
+		if e.Event == name && e.Err == nil {
+			events = append(events, e)
+		}
+	}
+	return events
+}
+
+// Reset discard recorded events, injected failures and chains are kept
+func (r *Recorder) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.events = nil
+}
+
+func (r *Recorder) record(ctx context.Context, env *event.Envelope) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	md := make(map[string]interface{}, len(env.Metadata))
+	for k, v := range env.Metadata {
+		md[k] = v
+	}
+
+	err := r.failures[env.Topic]
+	r.events = append(r.events, Recorded{
+		Event:    env.Event,
+		Topic:    env.Topic,
+		Key:      env.Key,
+		Sequence: env.Sequence,
+		Message:  env.Message,
+		Metadata: md,
+		Err:      err,
+	})
+	return err
+}
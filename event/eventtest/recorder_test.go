@@ -0,0 +1,88 @@
+package eventtest
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/sahalazain/go-common/config"
+	"github.com/sahalazain/go-common/event"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecorder(t *testing.T) {
+	cfg := map[string]interface{}{
+		"cache_url": "mem://recorder",
+		"config": map[string]interface{}{
+			"event_map": map[string]interface{}{"parcel": "logistic.parcel"},
+			"metadata": map[string]interface{}{
+				"default": map[string]interface{}{"source": "test"},
+			},
+		},
+	}
+	conf, err := config.Load(cfg, "")
+	assert.Nil(t, err)
+
+	r, err := NewRecorder(conf)
+	assert.Nil(t, err)
+
+	ctx := context.Background()
+	assert.Nil(t, r.Push(ctx, "parcel", "P1", map[string]interface{}{"status": "picked"}, map[string]interface{}{"courier": "C1"}))
+	assert.Nil(t, r.Publish(ctx, "manifest", "M1", nil))
+
+	events := r.Events()
+	assert.Len(t, events, 2)
+	assert.Equal(t, "parcel", events[0].Event)
+	assert.Equal(t, "logistic.parcel", events[0].Topic)
+	assert.Equal(t, "P1", events[0].Key)
+	assert.Equal(t, int64(1), events[0].Sequence)
+	assert.Equal(t, "test", events[0].Metadata["source"])
+	assert.Equal(t, "C1", events[0].Metadata["courier"])
+	assert.NotEmpty(t, events[1].Key)
+	assert.Equal(t, int64(0), events[1].Sequence)
+
+	// injected failure is returned and recorded, chain is not advanced
+	ferr := errors.New("broker down")
+	r.Fail("logistic.parcel", ferr)
+	assert.Equal(t, ferr, r.Push(ctx, "parcel", "P1", map[string]interface{}{"status": "lost"}, nil))
+	assert.Nil(t, r.Publish(ctx, "manifest", "M2", nil))
+
+	r.Fail("logistic.parcel", nil)
+	assert.Nil(t, r.Push(ctx, "parcel", "P1", map[string]interface{}{"status": "delivered"}, nil))
+
+	events = r.Events()
+	assert.Len(t, events, 5)
+	assert.Equal(t, ferr, events[2].Err)
+	assert.Len(t, r.Published("parcel"), 2)
+	assert.Equal(t, int64(2), r.Published("parcel")[1].Sequence)
+
+	// failed batch entries are reported by index
+	r.Fail("manifest", ferr)
+	err = r.PublishBatch(ctx, []event.Event{{Name: "manifest", Message: "M3"}, {Name: "parcel", Key: "P2", Message: "P2"}})
+	assert.Equal(t, event.BatchError{0: ferr}, err)
+
+	r.Reset()
+	assert.Len(t, r.Events(), 0)
+
+	assert.Nil(t, r.Close(ctx))
+	assert.Equal(t, event.ErrEmitterClosed, r.Publish(ctx, "manifest", "M4", nil))
+}
+
+func TestRecorderDefault(t *testing.T) {
+	var em event.Emitter
+	r, err := NewRecorder(nil)
+	assert.Nil(t, err)
+	em = r
+
+	ctx := context.Background()
+	assert.Nil(t, em.Publish(ctx, "manifest", "M1", map[string]interface{}{"idempotency_key": "M1"}))
+	// duplicate is accepted without being sent again
+	assert.Nil(t, em.Publish(ctx, "manifest", "M1", map[string]interface{}{"idempotency_key": "M1"}))
+
+	events := r.Events()
+	assert.Len(t, events, 1)
+	assert.Equal(t, "manifest", events[0].Topic)
+	assert.Equal(t, 1, events[0].Metadata["version"])
+
+	assert.Nil(t, em.Close(ctx))
+}
@@ -0,0 +1,72 @@
+package event
+
+import (
+	"context"
+	"sync"
+)
+
+// groupMetadataKey metadata entry setting explicit delivery group of event
+const groupMetadataKey = "group_id"
+
+// groupOf delivery group of event, explicit group in metadata or key of sequential event.
+// Events without group are delivered independently
+func groupOf(env *Envelope) string {
+	if g, ok := env.Metadata[groupMetadataKey].(string); ok && g != "" {
+		return g
+	}
+	if env.Sequential {
+		return env.Key
+	}
+	return ""
+}
+
+// recordSender send single outbox record
+type recordSender func(ctx context.Context, o *OutboxRecord) error
+
+// sendGroups send records in their order within group, a failing record blocks the rest of its group
+// while other groups proceed in parallel. Records without group are sent independently.
+// Returns number of sent records and error of the earliest failed record
+func sendGroups(ctx context.Context, records []*OutboxRecord, send recordSender) (int, error) {
+	groups := make(map[string][]int)
+	order := make([]string, 0)
+	for i, o := range records {
+		if _, ok := groups[o.GroupID]; !ok {
+			order = append(order, o.GroupID)
+		}
+		groups[o.GroupID] = append(groups[o.GroupID], i)
+	}
+
+	errs := make([]error, len(records))
+	var wg sync.WaitGroup
+	for _, g := range order {
+		wg.Add(1)
+		go func(g string, idx []int) {
+			defer wg.Done()
+			for n, i := range idx {
+				if err := send(ctx, records[i]); err != nil {
+					errs[i] = err
+					if g == "" {
+						continue
+					}
+					// keep group order, later records wait for the failed one to be retried
+					for _, j := range idx[n+1:] {
+						errs[j] = err
+					}
+					return
+				}
+			}
+		}(g, groups[g])
+	}
+	wg.Wait()
+
+	sent := 0
+	var ferr error
+	for _, err := range errs {
+		if err == nil {
+			sent++
+		} else if ferr == nil {
+			ferr = err
+		}
+	}
+	return sent, ferr
+}
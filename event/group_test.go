@@ -0,0 +1,103 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sahalazain/go-common/config"
+	_ "github.com/sahalazain/simplecache/mem"
+	"github.com/stretchr/testify/assert"
+	"gocloud.dev/docstore"
+	_ "gocloud.dev/docstore/memdocstore"
+	_ "gocloud.dev/pubsub/mempubsub"
+)
+
+func TestGroupOf(t *testing.T) {
+	assert.Equal(t, "", groupOf(&Envelope{Key: "hash"}))
+	assert.Equal(t, "parcel", groupOf(&Envelope{Key: "parcel", Sequential: true}))
+	assert.Equal(t, "order", groupOf(&Envelope{Key: "parcel", Sequential: true, Metadata: map[string]interface{}{"group_id": "order"}}))
+	assert.Equal(t, "order", groupOf(&Envelope{Key: "hash", Metadata: map[string]interface{}{"group_id": "order"}}))
+}
+
+func TestSendGroups(t *testing.T) {
+	records := []*OutboxRecord{
+		{ID: "a1", GroupID: "a"},
+		{ID: "b1", GroupID: "b"},
+		{ID: "n1"},
+		{ID: "a2", GroupID: "a"},
+		{ID: "b2", GroupID: "b"},
+		{ID: "n2"},
+		{ID: "b3", GroupID: "b"},
+	}
+
+	var mu sync.Mutex
+	sent := make(map[string][]string)
+	failed := errors.New("failed")
+
+	n, err := sendGroups(context.Background(), records, func(ctx context.Context, o *OutboxRecord) error {
+		if o.ID == "a1" || o.ID == "n1" {
+			return failed
+		}
+		mu.Lock()
+		sent[o.GroupID] = append(sent[o.GroupID], o.ID)
+		mu.Unlock()
+		return nil
+	})
+
+	assert.Equal(t, failed, err)
+	assert.Equal(t, 4, n)
+	assert.Empty(t, sent["a"])
+	assert.Equal(t, []string{"b1", "b2", "b3"}, sent["b"])
+	assert.Equal(t, []string{"n2"}, sent[""])
+}
+
+func TestRelayGroup(t *testing.T) {
+	cfg := map[string]interface{}{
+		"collection_url": "mem://grouped/_id",
+		"cache_url":      "mem://gc",
+		"pubsub_url":     "mem://$TOPIC",
+	}
+	conf, err := config.Load(cfg, "")
+	assert.Nil(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	out, err := NewOutboxEmitter(ctx, conf)
+	assert.Nil(t, err)
+
+	relay, err := NewOutboxRelay(ctx, conf)
+	assert.Nil(t, err)
+
+	assert.Nil(t, out.Push(ctx, "broken?invalid=1", "parcel", map[string]interface{}{"seq": 1}, nil))
+	time.Sleep(time.Millisecond)
+	assert.Nil(t, out.Push(ctx, "grouped", "parcel", map[string]interface{}{"seq": 2}, nil))
+	assert.Nil(t, out.Push(ctx, "grouped", "courier", map[string]interface{}{"seq": 1}, nil))
+
+	n, err := relay.Relay(ctx)
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, n)
+
+	col, err := docstore.OpenCollection(ctx, "mem://grouped/_id")
+	assert.Nil(t, err)
+
+	iter := col.Query().Get(ctx)
+	defer iter.Stop()
+
+	pending := make(map[string]string)
+	for {
+		var o OutboxRecord
+		if err := iter.Next(ctx, &o); err != nil {
+			break
+		}
+		pending[o.KafkaTopic] = o.GroupID
+	}
+
+	// later record of failing group is kept
+	assert.Equal(t, map[string]string{"broken?invalid=1": "parcel", "grouped": "parcel"}, pending)
+
+	assert.Nil(t, relay.Close(ctx))
+}
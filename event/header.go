@@ -0,0 +1,98 @@
+package event
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// HeaderConfig forward event metadata fields as broker message headers.
+// Headers are resolved when event is emitted and stored with outbox records, so hybrid sender and relay forward them as is.
+type HeaderConfig struct {
+	// Fields metadata fields to forward, "*" forward all fields
+	Fields     []string `json:"fields,omitempty" mapstructure:"fields"`
+	Prefix     string   `json:"prefix,omitempty" mapstructure:"prefix"`
+	TimeFormat string   `json:"time_format,omitempty" mapstructure:"time_format"`
+}
+
+func (h *HeaderConfig) enabled() bool {
+	return len(h.Fields) > 0
+}
+
+func (h *HeaderConfig) all() bool {
+	for _, f := range h.Fields {
+		if f == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// forward copy selected metadata fields into envelope headers, existing headers such as trace context are kept
+func (h *HeaderConfig) forward(next SendFunc) SendFunc {
+	return func(ctx context.Context, env *Envelope) error {
+		fields := h.Fields
+		if h.all() {
+			fields = make([]string, 0, len(env.Metadata))
+			for k := range env.Metadata {
+				fields = append(fields, k)
+			}
+		}
+
+		for _, f := range fields {
+			v, ok := env.Metadata[f]
+			if !ok || v == nil {
+				continue
+			}
+
+			name := h.Prefix + f
+			if name == kafkaKeyName {
+				continue
+			}
+			if _, ok := env.Headers[name]; ok {
+				continue
+			}
+
+			s, err := h.format(v)
+			if err != nil {
+				return err
+			}
+			env.setHeader(name, s)
+		}
+
+		return next(ctx, env)
+	}
+}
+
+// format convert metadata value to header string, scalars are formatted as is and composite values as JSON
+func (h *HeaderConfig) format(v interface{}) (string, error) {
+	switch t := v.(type) {
+	case string:
+		return t, nil
+	case []byte:
+		return string(t), nil
+	case bool:
+		return strconv.FormatBool(t), nil
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return fmt.Sprintf("%d", t), nil
+	case float32:
+		return strconv.FormatFloat(float64(t), 'f', -1, 32), nil
+	case float64:
+		return strconv.FormatFloat(t, 'f', -1, 64), nil
+	case time.Time:
+		if h.TimeFormat != "" {
+			return t.Format(h.TimeFormat), nil
+		}
+		return t.Format(time.RFC3339Nano), nil
+	case fmt.Stringer:
+		return t.String(), nil
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
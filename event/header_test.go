@@ -0,0 +1,124 @@
+package event
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sahalazain/go-common/config"
+	_ "github.com/sahalazain/simplecache/mem"
+	"github.com/stretchr/testify/assert"
+	"gocloud.dev/docstore"
+	_ "gocloud.dev/docstore/memdocstore"
+	"gocloud.dev/pubsub"
+	_ "gocloud.dev/pubsub/mempubsub"
+)
+
+func TestHeaderFormat(t *testing.T) {
+	h := &HeaderConfig{}
+
+	ts := time.Date(2021, 3, 4, 5, 6, 7, 0, time.UTC)
+	values := map[interface{}]string{
+		"text":     "text",
+		1:          "1",
+		int64(42):  "42",
+		1.5:        "1.5",
+		float64(2): "2",
+		true:       "true",
+		ts:         "2021-03-04T05:06:07Z",
+	}
+	for v, expected := range values {
+		s, err := h.format(v)
+		assert.Nil(t, err)
+		assert.Equal(t, expected, s)
+	}
+
+	s, err := h.format(map[string]interface{}{"a": 1})
+	assert.Nil(t, err)
+	assert.Equal(t, `{"a":1}`, s)
+
+	h.TimeFormat = "2006-01-02"
+	s, err = h.format(ts)
+	assert.Nil(t, err)
+	assert.Equal(t, "2021-03-04", s)
+}
+
+func TestPubsubHeaders(t *testing.T) {
+	cfg := map[string]interface{}{
+		"cache_url":  "mem://hdc",
+		"pubsub_url": "mem://$TOPIC",
+		"config": map[string]interface{}{
+			"headers": map[string]interface{}{
+				"fields": []string{"*"},
+				"prefix": "x-",
+			},
+		},
+	}
+	conf, err := config.Load(cfg, "")
+	assert.Nil(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err = pubsub.OpenTopic(ctx, "mem://headered")
+	assert.Nil(t, err)
+
+	sub, err := pubsub.OpenSubscription(ctx, "mem://headered")
+	assert.Nil(t, err)
+
+	ps, err := NewPubSubEmitter(ctx, conf)
+	assert.Nil(t, err)
+
+	err = ps.Push(ctx, "headered", "P1", map[string]interface{}{"status": "picked"}, map[string]interface{}{"origin": map[string]interface{}{"city": "Jakarta"}})
+	assert.Nil(t, err)
+
+	m, err := sub.Receive(ctx)
+	assert.Nil(t, err)
+	m.Ack()
+
+	assert.Equal(t, "P1", m.Metadata["key"])
+	assert.Equal(t, "headered", m.Metadata["x-event"])
+	assert.Equal(t, "1", m.Metadata["x-version"])
+	assert.Equal(t, "", m.Metadata["x-previous"])
+	assert.Equal(t, `{"city":"Jakarta"}`, m.Metadata["x-origin"])
+	assert.NotEmpty(t, m.Metadata["x-hash"])
+}
+
+func TestOutboxHeaders(t *testing.T) {
+	cfg := map[string]interface{}{
+		"collection_url": "mem://outboxheaders/_id",
+		"cache_url":      "mem://ohc",
+		"config": map[string]interface{}{
+			"headers": map[string]interface{}{
+				"fields": []string{"event", "version"},
+			},
+		},
+	}
+	conf, err := config.Load(cfg, "")
+	assert.Nil(t, err)
+
+	ctx := context.Background()
+
+	out, err := NewOutboxEmitter(ctx, conf)
+	assert.Nil(t, err)
+
+	err = out.Publish(ctx, "manifest", map[string]interface{}{"parcel": "P1"}, nil)
+	assert.Nil(t, err)
+
+	col, err := docstore.OpenCollection(ctx, "mem://outboxheaders/_id")
+	assert.Nil(t, err)
+
+	var o OutboxRecord
+	iter := col.Query().Get(ctx)
+	defer iter.Stop()
+	assert.Nil(t, iter.Next(ctx, &o))
+
+	assert.Equal(t, "manifest", o.Headers["event"])
+	assert.Equal(t, "1", o.Headers["version"])
+	_, ok := o.Headers["hash"]
+	assert.False(t, ok)
+
+	msg, err := o.toMessage()
+	assert.Nil(t, err)
+	assert.Equal(t, "manifest", msg.Metadata["event"])
+}
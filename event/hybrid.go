@@ -3,34 +3,59 @@ package event
 import (
 	"context"
 	"errors"
-	"net/url"
-	"strings"
+	"io"
+	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/imdario/mergo"
 	"github.com/sahalazain/go-common/config"
 	"github.com/sahalazain/go-common/logger"
 	"gocloud.dev/docstore"
 	"gocloud.dev/gcerrors"
-	"gocloud.dev/pubsub"
-	"gocloud.dev/pubsub/kafkapubsub"
 )
 
-//Hybrid hybrid outbox pubsub repository
+const (
+	defaultRecoveryAge      = time.Minute
+	defaultRecoveryInterval = time.Minute
+	recoveryBatchSize       = 1000
+	flushPollInterval       = 10 * time.Millisecond
+)
+
+//Hybrid hybrid outbox pubsub repository. Records of a group are sent in order by a sender of their group, records
+//left in collection longer than recovery age are claimed and re-sent by one of the emitter processes, recovery age
+//should exceed the longest retry of a send
 type Hybrid struct {
-	CollectionURL string      `json:"collection_url,omitempty" mapstructure:"collection_url"`
-	CacheURL      string      `json:"cache_url,omitempty" mapstructure:"cache_url"`
-	Config        EventConfig `json:"config,omitempty" mapstructure:"config"`
-	PubsubURL     string      `json:"pubsub_url,omitempty" mapstructure:"pubsub_url"`
-	KafkaBroker   string      `json:"kafka_broker,omitempty" mapstructure:"kafka_broker"`
-	collection    *docstore.Collection
-	topics        map[string]*pubsub.Topic
-	channel       chan *OutboxRecord
-	ec            *EmitterCache
+	CollectionURL    string        `json:"collection_url,omitempty" mapstructure:"collection_url"`
+	CacheURL         string        `json:"cache_url,omitempty" mapstructure:"cache_url"`
+	Config           EventConfig   `json:"config,omitempty" mapstructure:"config"`
+	PubsubURL        string        `json:"pubsub_url,omitempty" mapstructure:"pubsub_url"`
+	KafkaBroker      string        `json:"kafka_broker,omitempty" mapstructure:"kafka_broker"`
+	Kafka            KafkaConfig   `json:"kafka,omitempty" mapstructure:"kafka"`
+	RecoveryAge      time.Duration `json:"recovery_age,omitempty" mapstructure:"recovery_age"`
+	RecoveryInterval time.Duration `json:"recovery_interval,omitempty" mapstructure:"recovery_interval"`
+	MarkSent         bool          `json:"mark_sent,omitempty" mapstructure:"mark_sent"`
+	collection       *docstore.Collection
+	topics           *topicCache
+	channel          chan *OutboxRecord
+	ec               *EmitterCache
+	mu               sync.RWMutex
+	closed           bool
+	pending          int64
+	cancel           context.CancelFunc
+	done             chan struct{}
+	recovered        chan struct{}
+	interceptors     []Interceptor
+	imu              sync.Mutex
+	inflight         map[string]bool
+	sctx             context.Context
+	gmu              sync.Mutex
+	groups           map[string][]*OutboxRecord
+	gwg              sync.WaitGroup
+	closing          chan struct{}
 }
 
 //NewHybridEmitter create instance of hybrid emitter
-func NewHybridEmitter(ctx context.Context, conf config.Getter) (*Hybrid, error) {
+func NewHybridEmitter(ctx context.Context, conf config.Getter, opts ...Option) (*Hybrid, error) {
 
 	var hc Hybrid
 
@@ -50,11 +75,32 @@ func NewHybridEmitter(ctx context.Context, conf config.Getter) (*Hybrid, error)
 		return nil, errors.New("missing pubsub_url param")
 	}
 
+	kc, err := hc.Kafka.saramaConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := hc.Config.Encryption.openKeeper(ctx); err != nil {
+		return nil, err
+	}
+
+	if err := hc.Config.ClaimCheck.openBucket(ctx); err != nil {
+		return nil, err
+	}
+
 	col, err := docstore.OpenCollection(ctx, hc.CollectionURL)
 	if err != nil {
 		return nil, err
 	}
 
+	if hc.RecoveryAge <= 0 {
+		hc.RecoveryAge = defaultRecoveryAge
+	}
+
+	if hc.RecoveryInterval <= 0 {
+		hc.RecoveryInterval = defaultRecoveryInterval
+	}
+
 	hc.collection = col
 
 	ec, err := NewEmitterCache(hc.CacheURL)
@@ -64,10 +110,21 @@ func NewHybridEmitter(ctx context.Context, conf config.Getter) (*Hybrid, error)
 
 	hc.ec = ec
 
-	hc.topics = make(map[string]*pubsub.Topic)
+	hc.topics = newTopicCache(hc.PubsubURL, hc.KafkaBroker, kc)
 	hc.channel = make(chan *OutboxRecord)
+	hc.done = make(chan struct{})
+	hc.recovered = make(chan struct{})
+	hc.inflight = make(map[string]bool)
+	hc.groups = make(map[string][]*OutboxRecord)
+	hc.closing = make(chan struct{})
+	hc.sctx = ctx
+	hc.interceptors = newOptions(&hc.Config, opts).interceptors
+
+	rctx, cancel := context.WithCancel(ctx)
+	hc.cancel = cancel
 
 	go hc.sender(ctx)
+	go hc.recoverPending(rctx)
 	return &hc, nil
 }
 
@@ -82,74 +139,252 @@ func (h *Hybrid) Publish(ctx context.Context, event string, message interface{},
 }
 
 func (h *Hybrid) send(ctx context.Context, event, key string, message interface{}, metadata map[string]interface{}) error {
-	event = h.Config.getTopic(event)
-	md := h.Config.getMetadata(event)
+	return errorAt(h.PublishBatch(ctx, []Event{{Name: event, Key: key, Message: message, Metadata: metadata}}), 0)
+}
 
-	if metadata != nil {
-		if err := mergo.Merge(&md, metadata); err != nil {
-			return err
+// PublishBatch store batch of events using bulk actions and hand them to sender, failed entries are reported as BatchError
+func (h *Hybrid) PublishBatch(ctx context.Context, events []Event) error {
+	h.mu.RLock()
+	closed := h.closed
+	h.mu.RUnlock()
+
+	if closed {
+		return ErrEmitterClosed
+	}
+
+	envs, errs, unlock := h.Config.resolve(ctx, h.ec, events)
+	defer unlock()
+
+	intercept(ctx, h.interceptors, envs, errs, h.store)
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+func (h *Hybrid) store(ctx context.Context, envs []*Envelope) BatchError {
+	errs := make(BatchError)
+	records, idx := toRecords(ctx, envs, errs)
+	serrs, created := storeRecords(ctx, h.collection, documents(records))
+
+	for j, i := range idx {
+		if err := serrs[j]; err != nil {
+			errs[i] = err
+			continue
+		}
+		h.ec.remember(ctx, envs[i], h.Config.dedupeWindow())
+
+		if !created[j] {
+			continue
+		}
+
+		if err := h.ec.advanceChain(ctx, envs[i]); err != nil {
+			errs[i] = err
+		}
+
+		h.track(records[j])
+		enqueue := h.enqueue
+		if envs[i].Group != "" {
+			enqueue = h.enqueueGroup
+		}
+		if err := enqueue(ctx, records[j]); err != nil {
+			errs[i] = err
 		}
 	}
 
-	mhash, err := hash(message)
-	if err != nil {
-		return err
+	return errs
+}
+
+// track mark record as being sent by this process, returns false when it already is
+func (h *Hybrid) track(o *OutboxRecord) bool {
+	h.imu.Lock()
+	defer h.imu.Unlock()
+
+	if h.inflight[o.ID] {
+		return false
 	}
+	h.inflight[o.ID] = true
+	return true
+}
+
+func (h *Hybrid) untrack(o *OutboxRecord) {
+	h.imu.Lock()
+	defer h.imu.Unlock()
 
-	md["hash"] = mhash
+	delete(h.inflight, o.ID)
+}
 
-	seq := false
+// enqueue hand stored record to sender, record that could not be enqueued stays in collection for recovery
+func (h *Hybrid) enqueue(ctx context.Context, o *OutboxRecord) error {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
 
-	if key == "" {
-		key = mhash
-	} else {
-		seq = true
-		md["previous"] = h.ec.getPrevious(ctx, event+key)
+	if h.closed {
+		h.untrack(o)
+		return ErrEmitterClosed
 	}
 
-	msg := &EventMessage{
-		Data:     message,
-		Metadata: md,
+	atomic.AddInt64(&h.pending, 1)
+	select {
+	case h.channel <- o:
+	case <-ctx.Done():
+		atomic.AddInt64(&h.pending, -1)
+		h.untrack(o)
 	}
+	return nil
+}
 
-	b, err := msg.ToBytes()
-	if err != nil {
-		return err
+// enqueueGroup queue stored record of a group behind earlier records of the group, each group is sent by its own
+// sender one record at a time
+func (h *Hybrid) enqueueGroup(_ context.Context, o *OutboxRecord) error {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	if h.closed {
+		h.untrack(o)
+		return ErrEmitterClosed
 	}
 
-	ob := (&OutboxRecord{
-		KafkaKey:   key,
-		KafkaTopic: event,
-		KafkaValue: string(b),
-	}).GenerateID()
+	atomic.AddInt64(&h.pending, 1)
 
-	if err := h.collection.Get(ctx, ob); err != nil {
-		code := gcerrors.Code(err)
-		if code != gcerrors.NotFound {
-			return err
+	h.gmu.Lock()
+	defer h.gmu.Unlock()
+
+	queue, running := h.groups[o.GroupID]
+	h.groups[o.GroupID] = append(queue, o)
+	if !running {
+		h.gwg.Add(1)
+		go h.groupSender(h.sctx, o.GroupID)
+	}
+	return nil
+}
+
+// groupSender send queued records of group in order until queue is empty. Failed record holds back the rest of its
+// group and is claimed again and re-sent after backoff, so recovery of other processes leaves it alone. Once emitter
+// is closing, or record was taken over meanwhile, remaining records of the group are left to recovery
+func (h *Hybrid) groupSender(ctx context.Context, group string) {
+	defer h.gwg.Done()
+
+	for attempt := 1; ; {
+		h.gmu.Lock()
+		queue := h.groups[group]
+		if len(queue) == 0 {
+			delete(h.groups, group)
+			h.gmu.Unlock()
+			return
+		}
+		o := queue[0]
+		h.gmu.Unlock()
+
+		if err := h.deliver(ctx, o); err == nil {
+			attempt = 1
+			h.dequeue(group, 1)
+			continue
+		}
+
+		select {
+		case <-h.closing:
+			h.dequeue(group, -1)
+			return
+		case <-time.After(h.Config.Retry.backoff(attempt)):
+			attempt++
+		}
+
+		if ok, err := h.claim(ctx, o); err != nil || !ok {
+			h.dequeue(group, -1)
+			return
 		}
 	}
+}
+
+// dequeue remove first n records of group queue. Negative n drops the whole queue and its sender must stop,
+// otherwise the emptied queue is removed by its sender so no second sender is started meanwhile
+func (h *Hybrid) dequeue(group string, n int) {
+	h.gmu.Lock()
+	defer h.gmu.Unlock()
 
-	if !ob.CreatedAt.IsZero() {
+	queue := h.groups[group]
+	if n < 0 {
+		n = len(queue)
+		delete(h.groups, group)
+	} else {
+		h.groups[group] = queue[n:]
+	}
+
+	for _, o := range queue[:n] {
+		h.untrack(o)
+		atomic.AddInt64(&h.pending, -1)
+	}
+}
+
+// Flush wait until all enqueued records are sent
+func (h *Hybrid) Flush(ctx context.Context) error {
+	ticker := time.NewTicker(flushPollInterval)
+	defer ticker.Stop()
+
+	for atomic.LoadInt64(&h.pending) > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// Close stop accepting events and recovery, drain enqueued records, shutdown topics and close collection
+func (h *Hybrid) Close(ctx context.Context) error {
+	h.cancel()
+
+	h.mu.Lock()
+	if h.closed {
+		h.mu.Unlock()
 		return nil
 	}
+	h.closed = true
+	close(h.channel)
+	close(h.closing)
+	h.mu.Unlock()
+
+	grouped := make(chan struct{})
+	go func() {
+		h.gwg.Wait()
+		close(grouped)
+	}()
+
+	// recovery may still be sending with topics and collection
+	for _, done := range []chan struct{}{h.done, grouped, h.recovered} {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-done:
+		}
+	}
 
-	ob.CreatedAt = time.Now()
+	rerr := h.topics.shutdown(ctx)
 
-	if err := h.collection.Create(ctx, ob); err != nil {
-		return err
+	if err := h.collection.Close(); err != nil {
+		rerr = err
 	}
 
-	if seq {
-		h.ec.setCurrent(ctx, event+key, mhash)
-	} else {
-		h.channel <- ob
+	if err := h.ec.Close(); err != nil {
+		rerr = err
 	}
 
-	return nil
+	if err := h.Config.Encryption.closeKeeper(); err != nil {
+		rerr = err
+	}
+
+	if err := h.Config.ClaimCheck.closeBucket(); err != nil {
+		rerr = err
+	}
+	return rerr
 }
 
 func (h *Hybrid) sender(ctx context.Context) error {
+	defer close(h.done)
+
 	log := logger.GetLoggerContext(ctx, "event", "hybridSender")
 	if h.topics == nil {
 		log.Error("Hybrid emitter is not configured")
@@ -157,60 +392,144 @@ func (h *Hybrid) sender(ctx context.Context) error {
 	}
 
 	for o := range h.channel {
+		_ = h.deliver(ctx, o)
+		h.untrack(o)
+		atomic.AddInt64(&h.pending, -1)
+	}
+	return nil
+}
 
-		if _, ok := h.topics[o.KafkaTopic]; !ok {
+// deliver send record and remove or mark it as sent, returns send error
+func (h *Hybrid) deliver(ctx context.Context, o *OutboxRecord) error {
+	log := logger.GetLoggerContext(ctx, "event", "hybridSender")
 
-			to := strings.ReplaceAll(h.PubsubURL, "$TOPIC", o.KafkaTopic)
+	if err := sendRecord(ctx, &h.Config.Retry, h.topics.get, o); err != nil {
+		log.WithError(err).WithField("topic", o.KafkaTopic).WithField("message", o.KafkaValue).Error("Error sending event")
+		if ferr := markFailed(ctx, h.collection, o, err); ferr != nil {
+			log.WithError(ferr).WithField("id", o.ID).Warn("Error recording failure")
+		}
+		return err
+	}
 
-			u, err := url.Parse(to)
-			if err != nil {
-				return err
-			}
+	if h.MarkSent {
+		if err := markSent(ctx, h.collection, o); err != nil {
+			log.WithError(err).WithField("topic", o.KafkaTopic).WithField("id", o.ID).Error("Error marking event sent")
+		}
+		return nil
+	}
 
-			if u.Scheme == "kafka" {
-				brokers := h.KafkaBroker
-				if strings.Contains(u.Host, ":") {
-					brokers = u.Host
-				}
-
-				if brokers == "" {
-					return errors.New("missing kafka broker")
-				}
-
-				topic, err := kafkapubsub.OpenTopic(strings.Split(brokers, ","), kafkapubsub.MinimalConfig(), o.KafkaTopic, &kafkapubsub.TopicOptions{KeyName: "key"})
-				if err != nil {
-					return err
-				}
-				h.topics[o.KafkaTopic] = topic
-			} else {
-				topic, err := pubsub.OpenTopic(ctx, to)
-				if err != nil {
-					return err
-				}
-				h.topics[o.KafkaTopic] = topic
-			}
+	if err := h.collection.Delete(ctx, o); err != nil {
+		log.WithError(err).WithField("topic", o.KafkaTopic).WithField("id", o.ID).Error("Error deleting event")
+	}
+	return nil
+}
+
+// recoverPending re-enqueue records left in collection, e.g. when process died before sending
+// or sending failed, once on startup and then every recovery interval
+func (h *Hybrid) recoverPending(ctx context.Context) {
+	defer close(h.recovered)
+
+	log := logger.GetLoggerContext(ctx, "event", "hybridRecovery")
+
+	ticker := time.NewTicker(h.RecoveryInterval)
+	defer ticker.Stop()
+
+	for {
+		if err := h.requeue(ctx); err != nil {
+			log.WithError(err).Error("Error re-enqueueing pending events")
 		}
 
-		t := h.topics[o.KafkaTopic]
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (h *Hybrid) requeue(ctx context.Context) error {
+	q := h.collection.Query()
+	if h.MarkSent {
+		q = q.Where("sent_at", "=", time.Time{})
+	}
 
-		//k := sha256.Sum256(b)
-		msg := &pubsub.Message{
-			Body: []byte(o.KafkaValue),
-			Metadata: map[string]string{
-				"key": o.KafkaKey,
-			},
+	iter := q.Where("created_at", "<", time.Now().Add(-h.RecoveryAge)).
+		OrderBy("created_at", docstore.Ascending).
+		Limit(recoveryBatchSize).
+		Get(ctx)
+	defer iter.Stop()
+
+	records := make([]*OutboxRecord, 0)
+	for {
+		var o OutboxRecord
+		err := iter.Next(ctx, &o)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return err
 		}
+		records = append(records, &o)
+	}
 
-		if err := t.Send(ctx, msg); err != nil {
-			log.WithError(err).WithField("topic", o.KafkaTopic).WithField("message", o.KafkaValue).Error("Error sending event")
+	// records claimed recently are being re-sent by another process. Recovery must not get ahead of an earlier
+	// record of the same group it could not take
+	cutoff := time.Now().Add(-h.RecoveryAge)
+	blocked := make(map[string]bool)
+	for _, o := range records {
+		if o.GroupID != "" && blocked[o.GroupID] {
 			continue
 		}
 
-		if err := h.collection.Delete(ctx, o); err != nil {
-			log.WithError(err).WithField("topic", o.KafkaTopic).WithField("id", o.ID).Error("Error deleting event")
+		taken, err := h.take(ctx, o, cutoff)
+		if err != nil {
+			return err
+		}
+		if !taken {
+			if o.GroupID != "" {
+				blocked[o.GroupID] = true
+			}
 			continue
 		}
 
+		enqueue := h.enqueue
+		if o.GroupID != "" {
+			enqueue = h.enqueueGroup
+		}
+		if err := enqueue(ctx, o); err != nil {
+			return err
+		}
+	}
+
+	return ctx.Err()
+}
+
+// take track and claim stale record, false when it is being sent by this process, e.g. in retry backoff,
+// or was claimed by another process after cutoff or meanwhile
+func (h *Hybrid) take(ctx context.Context, o *OutboxRecord, cutoff time.Time) (bool, error) {
+	if o.ClaimedAt.After(cutoff) || !h.track(o) {
+		return false, nil
+	}
+
+	ok, err := h.claim(ctx, o)
+	if err != nil || !ok {
+		h.untrack(o)
+		return false, err
+	}
+	return true, nil
+}
+
+// claim take over stale record before re-sending it, so only one process re-sends it. Update is checked against
+// revision read by recovery query, record that was changed or claimed meanwhile is skipped
+func (h *Hybrid) claim(ctx context.Context, o *OutboxRecord) (bool, error) {
+	err := h.collection.Update(ctx, o, docstore.Mods{"claimed_at": time.Now()})
+	if err == nil {
+		return true, nil
+	}
+
+	switch gcerrors.Code(err) {
+	case gcerrors.FailedPrecondition, gcerrors.NotFound:
+		return false, nil
+	default:
+		return false, err
 	}
-	return nil
 }
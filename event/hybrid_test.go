@@ -0,0 +1,332 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sahalazain/go-common/config"
+	_ "github.com/sahalazain/simplecache/mem"
+	"github.com/stretchr/testify/assert"
+	"gocloud.dev/docstore"
+	_ "gocloud.dev/docstore/memdocstore"
+	"gocloud.dev/gcerrors"
+	"gocloud.dev/pubsub"
+	"gocloud.dev/pubsub/mempubsub"
+)
+
+func TestHybridRecovery(t *testing.T) {
+	cfg := map[string]interface{}{
+		"collection_url":    "mem://hybrid/_id",
+		"cache_url":         "mem://hc",
+		"pubsub_url":        "mem://$TOPIC",
+		"recovery_age":      "10ms",
+		"recovery_interval": "50ms",
+	}
+	conf, err := config.Load(cfg, "")
+	assert.Nil(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	col, err := docstore.OpenCollection(ctx, "mem://hybrid/_id")
+	assert.Nil(t, err)
+
+	// record stored by a process that died before sending it
+	stuck := (&OutboxRecord{
+		KafkaKey:   "parcel",
+		KafkaTopic: "recovered",
+		KafkaValue: `{"data":{"city":"Jakarta"}}`,
+	}).GenerateID()
+	stuck.CreatedAt = time.Now().Add(-time.Hour)
+	assert.Nil(t, col.Create(ctx, stuck))
+
+	_, err = pubsub.OpenTopic(ctx, "mem://recovered")
+	assert.Nil(t, err)
+
+	sub, err := pubsub.OpenSubscription(ctx, "mem://recovered")
+	assert.Nil(t, err)
+
+	hc, err := NewHybridEmitter(ctx, conf)
+	assert.Nil(t, err)
+	assert.NotNil(t, hc)
+
+	m, err := sub.Receive(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, "parcel", m.Metadata["key"])
+	assert.Equal(t, stuck.KafkaValue, string(m.Body))
+	m.Ack()
+
+	assert.Eventually(t, func() bool {
+		return gcerrors.Code(col.Get(ctx, &OutboxRecord{ID: stuck.ID})) == gcerrors.NotFound
+	}, 5*time.Second, 20*time.Millisecond)
+}
+
+func TestHybridRecoveryClaim(t *testing.T) {
+	cfg := map[string]interface{}{
+		"collection_url":    "mem://hybridclaim/_id",
+		"cache_url":         "mem://hcl",
+		"pubsub_url":        "mem://$TOPIC",
+		"recovery_age":      "50ms",
+		"recovery_interval": "1h",
+	}
+	conf, err := config.Load(cfg, "")
+	assert.Nil(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err = pubsub.OpenTopic(ctx, "mem://claimed")
+	assert.Nil(t, err)
+
+	sub, err := pubsub.OpenSubscription(ctx, "mem://claimed")
+	assert.Nil(t, err)
+
+	// replicas sharing outbox, startup recovery finds nothing
+	h1, err := NewHybridEmitter(ctx, conf)
+	assert.Nil(t, err)
+	h2, err := NewHybridEmitter(ctx, conf)
+	assert.Nil(t, err)
+	time.Sleep(20 * time.Millisecond)
+
+	col, err := docstore.OpenCollection(ctx, "mem://hybridclaim/_id")
+	assert.Nil(t, err)
+
+	for i := 0; i < 3; i++ {
+		stuck := (&OutboxRecord{
+			KafkaTopic: "claimed",
+			KafkaValue: fmt.Sprintf(`{"data":{"seq":%d}}`, i),
+		}).GenerateID()
+		stuck.CreatedAt = time.Now().Add(-time.Hour)
+		assert.Nil(t, col.Create(ctx, stuck))
+	}
+
+	stale := func() []*OutboxRecord {
+		records := make([]*OutboxRecord, 0)
+		iter := col.Query().Get(ctx)
+		defer iter.Stop()
+		for {
+			var o OutboxRecord
+			if err := iter.Next(ctx, &o); err != nil {
+				return records
+			}
+			records = append(records, &o)
+		}
+	}
+
+	// both replicas read the same records, only the first claim wins
+	read1, read2 := stale(), make(map[string]*OutboxRecord)
+	for _, o := range stale() {
+		read2[o.ID] = o
+	}
+	assert.Len(t, read1, 3)
+	for _, o := range read1 {
+		ok, err := h1.claim(ctx, o)
+		assert.Nil(t, err)
+		assert.True(t, ok)
+
+		ok, err = h2.claim(ctx, read2[o.ID])
+		assert.Nil(t, err)
+		assert.False(t, ok)
+	}
+
+	// recently claimed records are left to their claimer
+	assert.Nil(t, h2.requeue(ctx))
+	rctx, rcancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	_, err = sub.Receive(rctx)
+	rcancel()
+	assert.NotNil(t, err)
+
+	// claim of a process that died expires after recovery age
+	assert.Nil(t, h2.requeue(ctx))
+	for i := 0; i < 3; i++ {
+		m, err := sub.Receive(ctx)
+		assert.Nil(t, err)
+		m.Ack()
+	}
+
+	// record in flight in this process is not sent twice, nor is it overtaken by later records of its group
+	o := (&OutboxRecord{KafkaTopic: "claimed", KafkaValue: `{"data":{"seq":3}}`, GroupID: "parcel"}).GenerateID()
+	o.CreatedAt = time.Now().Add(-time.Hour)
+	assert.Nil(t, col.Create(ctx, o))
+	next := (&OutboxRecord{KafkaTopic: "claimed", KafkaValue: `{"data":{"seq":4}}`, GroupID: "parcel"}).GenerateID()
+	next.CreatedAt = o.CreatedAt.Add(time.Second)
+	assert.Nil(t, col.Create(ctx, next))
+	assert.True(t, h1.track(o))
+	assert.Nil(t, h1.requeue(ctx))
+	for _, id := range []string{o.ID, next.ID} {
+		got := &OutboxRecord{ID: id}
+		assert.Nil(t, col.Get(ctx, got))
+		assert.True(t, got.ClaimedAt.IsZero())
+	}
+}
+
+func TestHybridRecoveryGroup(t *testing.T) {
+	cfg := map[string]interface{}{
+		"collection_url":    "mem://hybridrecgroup/_id",
+		"cache_url":         "mem://hcrg",
+		"pubsub_url":        "mem://$TOPIC",
+		"recovery_age":      "1m",
+		"recovery_interval": "1h",
+	}
+	conf, err := config.Load(cfg, "")
+	assert.Nil(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err = pubsub.OpenTopic(ctx, "mem://regrouped")
+	assert.Nil(t, err)
+
+	sub, err := pubsub.OpenSubscription(ctx, "mem://regrouped")
+	assert.Nil(t, err)
+
+	h1, err := NewHybridEmitter(ctx, conf)
+	assert.Nil(t, err)
+	h2, err := NewHybridEmitter(ctx, conf)
+	assert.Nil(t, err)
+	time.Sleep(20 * time.Millisecond)
+
+	col, err := docstore.OpenCollection(ctx, "mem://hybridrecgroup/_id")
+	assert.Nil(t, err)
+
+	created := time.Now().Add(-time.Hour)
+	records := make([]*OutboxRecord, 0, 3)
+	for i, group := range []string{"parcel", "parcel", ""} {
+		o := (&OutboxRecord{
+			KafkaTopic: "regrouped",
+			KafkaValue: fmt.Sprintf(`{"data":{"seq":%d}}`, i),
+			GroupID:    group,
+		}).GenerateID()
+		o.CreatedAt = created.Add(time.Duration(i) * time.Second)
+		assert.Nil(t, col.Create(ctx, o))
+		records = append(records, o)
+	}
+
+	// first record of group is being re-sent by replica 1, e.g. failing in retry backoff
+	r1 := &OutboxRecord{ID: records[0].ID}
+	assert.Nil(t, col.Get(ctx, r1))
+	ok, err := h1.claim(ctx, r1)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	// replica 2 recovers ungrouped record but leaves the rest of the group
+	assert.Nil(t, h2.requeue(ctx))
+	m, err := sub.Receive(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, records[2].KafkaValue, string(m.Body))
+	m.Ack()
+
+	r2 := &OutboxRecord{ID: records[1].ID}
+	assert.Nil(t, col.Get(ctx, r2))
+	assert.True(t, r2.ClaimedAt.IsZero())
+
+	rctx, rcancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	_, err = sub.Receive(rctx)
+	rcancel()
+	assert.NotNil(t, err)
+}
+
+func TestHybridClose(t *testing.T) {
+	cfg := map[string]interface{}{
+		"collection_url": "mem://hybridclose/_id",
+		"cache_url":      "mem://hcc",
+		"pubsub_url":     "mem://$TOPIC",
+	}
+	conf, err := config.Load(cfg, "")
+	assert.Nil(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err = pubsub.OpenTopic(ctx, "mem://closing")
+	assert.Nil(t, err)
+
+	sub, err := pubsub.OpenSubscription(ctx, "mem://closing")
+	assert.Nil(t, err)
+
+	hc, err := NewHybridEmitter(ctx, conf)
+	assert.Nil(t, err)
+
+	for i := 0; i < 5; i++ {
+		err = hc.Publish(ctx, "closing", map[string]interface{}{"seq": i}, nil)
+		assert.Nil(t, err)
+	}
+
+	assert.Nil(t, hc.Flush(ctx))
+	assert.Nil(t, hc.Close(ctx))
+	select {
+	case <-hc.recovered:
+	default:
+		t.Fatal("recovery still running after close")
+	}
+	assert.Equal(t, ErrEmitterClosed, hc.Publish(ctx, "closing", map[string]interface{}{"seq": 5}, nil))
+
+	for i := 0; i < 5; i++ {
+		m, err := sub.Receive(ctx)
+		assert.Nil(t, err)
+		m.Ack()
+	}
+}
+
+func TestHybridGroup(t *testing.T) {
+	cfg := map[string]interface{}{
+		"collection_url":    "mem://hybridgroup/_id",
+		"cache_url":         "mem://hcg",
+		"pubsub_url":        "mem://$TOPIC",
+		"recovery_interval": "1h",
+		"mark_sent":         true,
+		"config": map[string]interface{}{
+			"retry": map[string]interface{}{"initial_backoff": "1ms", "max_backoff": "5ms"},
+		},
+	}
+	conf, err := config.Load(cfg, "")
+	assert.Nil(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	col, err := docstore.OpenCollection(ctx, "mem://hybridgroup/_id")
+	assert.Nil(t, err)
+
+	hc, err := NewHybridEmitter(ctx, conf)
+	assert.Nil(t, err)
+
+	// first send fails, the rest of the group waits for it
+	var opened int64
+	hc.topics.open = func(ctx context.Context, name string) (*pubsub.Topic, error) {
+		if atomic.AddInt64(&opened, 1) == 1 {
+			return nil, errors.New("broker unavailable")
+		}
+		return mempubsub.NewTopic(), nil
+	}
+
+	// grouped records are sent right away, not left to recovery
+	for i := 0; i < 5; i++ {
+		assert.Nil(t, hc.Push(ctx, "grouped", "parcel", map[string]interface{}{"seq": i}, nil))
+	}
+	assert.Nil(t, hc.Flush(ctx))
+
+	iter := col.Query().Where("created_at", ">", time.Time{}).OrderBy("created_at", docstore.Ascending).Get(ctx)
+	sent := make([]time.Time, 0)
+	for {
+		var o OutboxRecord
+		if err := iter.Next(ctx, &o); err != nil {
+			assert.Equal(t, io.EOF, err)
+			break
+		}
+		assert.Equal(t, statusSent, o.Status)
+		sent = append(sent, o.SentAt)
+	}
+	iter.Stop()
+
+	assert.Len(t, sent, 5)
+	for i := 1; i < len(sent); i++ {
+		assert.False(t, sent[i].Before(sent[i-1]), "record %d sent before record %d", i, i-1)
+	}
+	assert.Nil(t, hc.Close(ctx))
+}
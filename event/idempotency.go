@@ -0,0 +1,67 @@
+package event
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"time"
+)
+
+const (
+	// idempotencyMetadataKey metadata entry carrying idempotency key of event
+	idempotencyMetadataKey = "idempotency_key"
+
+	defaultDedupeWindow = 24 * time.Hour
+)
+
+// idempotencyKey explicit idempotency key of event, from batch entry or metadata
+func idempotencyKey(ev Event, md map[string]interface{}) string {
+	if ev.IdempotencyKey != "" {
+		return ev.IdempotencyKey
+	}
+	k, _ := md[idempotencyMetadataKey].(string)
+	return k
+}
+
+// dedupeWindow how long delivered idempotency keys are remembered
+func (c *EventConfig) dedupeWindow() time.Duration {
+	if c.DedupeWindow > 0 {
+		return c.DedupeWindow
+	}
+	return defaultDedupeWindow
+}
+
+// seen whether event with idempotency key was already delivered to topic within dedupe window
+func (e *EmitterCache) seen(ctx context.Context, topic, key string) bool {
+	if e.cache == nil {
+		return false
+	}
+	return e.cache.Exist(ctx, e.keyName+"idempotency/"+topic+"/"+key)
+}
+
+// remember store idempotency key of delivered event for dedupe window
+func (e *EmitterCache) remember(ctx context.Context, env *Envelope, window time.Duration) {
+	if e.cache == nil || env.IdempotencyKey == "" {
+		return
+	}
+
+	// cache expiration is in seconds
+	ttl := int((window + time.Second - 1) / time.Second)
+	e.cache.Set(ctx, e.keyName+"idempotency/"+env.Topic+"/"+env.IdempotencyKey, env.Hash, ttl)
+}
+
+// recordID outbox record ID, derived from idempotency key so the same event is stored once,
+// otherwise unique so identical payloads published again are kept
+func recordID(env *Envelope) (string, error) {
+	if env.IdempotencyKey != "" {
+		h := sha256.Sum256([]byte(env.Topic + "/" + env.IdempotencyKey))
+		return base64.StdEncoding.EncodeToString(h[:]), nil
+	}
+
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(b), nil
+}
@@ -0,0 +1,144 @@
+package event
+
+import (
+	"context"
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sahalazain/go-common/config"
+	_ "github.com/sahalazain/simplecache/mem"
+	"github.com/stretchr/testify/assert"
+	"gocloud.dev/docstore"
+	_ "gocloud.dev/docstore/memdocstore"
+	"gocloud.dev/pubsub"
+	_ "gocloud.dev/pubsub/mempubsub"
+)
+
+func TestOutboxIdempotency(t *testing.T) {
+	cfg := map[string]interface{}{
+		"collection_url": "mem://idempotent/_id",
+		"cache_url":      "mem://ic",
+	}
+	conf, err := config.Load(cfg, "")
+	assert.Nil(t, err)
+
+	ctx := context.Background()
+
+	out, err := NewOutboxEmitter(ctx, conf)
+	assert.Nil(t, err)
+
+	// parcel going back to the same status is kept
+	obj := map[string]interface{}{"parcel": "P1", "status": "transit"}
+	assert.Nil(t, out.Publish(ctx, "status", obj, nil))
+	assert.Nil(t, out.Publish(ctx, "status", obj, nil))
+
+	md := map[string]interface{}{"idempotency_key": "P1-delivered"}
+	delivered := map[string]interface{}{"parcel": "P1", "status": "delivered"}
+	assert.Nil(t, out.Publish(ctx, "status", delivered, md))
+	assert.Nil(t, out.Publish(ctx, "status", delivered, md))
+
+	assert.Nil(t, out.PublishBatch(ctx, []Event{
+		{Name: "status", Message: obj, IdempotencyKey: "P2-transit"},
+		{Name: "status", Message: obj, IdempotencyKey: "P2-transit"},
+		{Name: "manifest", Message: obj, IdempotencyKey: "P2-transit"},
+	}))
+
+	col, err := docstore.OpenCollection(ctx, "mem://idempotent/_id")
+	assert.Nil(t, err)
+
+	iter := col.Query().Get(ctx)
+	defer iter.Stop()
+
+	count := map[string]int{}
+	for {
+		var o OutboxRecord
+		err := iter.Next(ctx, &o)
+		if err == io.EOF {
+			break
+		}
+		assert.Nil(t, err)
+		count[o.KafkaTopic]++
+	}
+
+	assert.Equal(t, 4, count["status"])
+	// idempotency key is scoped to topic
+	assert.Equal(t, 1, count["manifest"])
+	assert.True(t, out.ec.seen(ctx, "status", "P1-delivered"))
+	assert.False(t, out.ec.seen(ctx, "status", "P1-transit"))
+}
+
+func TestPubsubIdempotency(t *testing.T) {
+	cfg := map[string]interface{}{
+		"cache_url":  "mem://pic",
+		"pubsub_url": "mem://$TOPIC",
+		"config": map[string]interface{}{
+			"dedupe_window": "1h",
+		},
+	}
+	conf, err := config.Load(cfg, "")
+	assert.Nil(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err = pubsub.OpenTopic(ctx, "mem://idempotent")
+	assert.Nil(t, err)
+
+	sub, err := pubsub.OpenSubscription(ctx, "mem://idempotent")
+	assert.Nil(t, err)
+
+	ps, err := NewPubSubEmitter(ctx, conf)
+	assert.Nil(t, err)
+	assert.Equal(t, time.Hour, ps.Config.dedupeWindow())
+
+	md := map[string]interface{}{"idempotency_key": "P1-picked"}
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, ps.Publish(ctx, "idempotent", map[string]interface{}{"attempt": i}, md))
+	}
+
+	m, err := sub.Receive(ctx)
+	assert.Nil(t, err)
+	m.Ack()
+
+	var msg EventMessage
+	assert.Nil(t, jsonCodec{}.Unmarshal(m.Body, &msg))
+	assert.Equal(t, "P1-picked", msg.Metadata["idempotency_key"])
+
+	rctx, rcancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer rcancel()
+	_, err = sub.Receive(rctx)
+	assert.NotNil(t, err)
+}
+
+func TestPubsubIdempotencyConcurrent(t *testing.T) {
+	conf, err := config.Load(map[string]interface{}{"cache_url": "mem://picc"}, "")
+	assert.Nil(t, err)
+
+	ctx := context.Background()
+
+	var sent int64
+	ps, err := NewPubSubEmitter(ctx, conf, WithSender(func(ctx context.Context, env *Envelope) error {
+		atomic.AddInt64(&sent, 1)
+		// widen the window between seen check and remembering the key
+		time.Sleep(5 * time.Millisecond)
+		return nil
+	}))
+	assert.Nil(t, err)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			md := map[string]interface{}{"idempotency_key": "P1-picked"}
+			assert.Nil(t, ps.Publish(ctx, "idempotent", map[string]interface{}{"attempt": i}, md))
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Equal(t, int64(1), atomic.LoadInt64(&sent))
+	assert.Nil(t, ps.Close(ctx))
+}
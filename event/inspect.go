@@ -0,0 +1,132 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/sahalazain/go-common/config"
+	"gocloud.dev/docstore"
+)
+
+// OutboxInspector list, decode and delete outbox records for operation tooling
+type OutboxInspector struct {
+	CollectionURL string `json:"collection_url,omitempty" mapstructure:"collection_url"`
+	// Config decode stored records, must have codecs, encryption and claim check of the emitter that stored them
+	Config     EventConfig `json:"config,omitempty" mapstructure:"config"`
+	collection *docstore.Collection
+}
+
+// PendingFilter filter of pending records, zero value match every pending record
+type PendingFilter struct {
+	Topic string
+	// MinAge only records created at least this long ago
+	MinAge time.Duration
+	Limit  int
+}
+
+// NewOutboxInspector create outbox inspector instance
+func NewOutboxInspector(ctx context.Context, conf config.Getter) (*OutboxInspector, error) {
+	var i OutboxInspector
+
+	if err := conf.Unmarshal(&i); err != nil {
+		return nil, err
+	}
+
+	if i.CollectionURL == "" {
+		return nil, errors.New("missing collection_url param")
+	}
+
+	if err := i.Config.Encryption.openKeeper(ctx); err != nil {
+		return nil, err
+	}
+
+	if err := i.Config.ClaimCheck.openBucket(ctx); err != nil {
+		return nil, err
+	}
+
+	col, err := docstore.OpenCollection(ctx, i.CollectionURL)
+	if err != nil {
+		return nil, err
+	}
+	i.collection = col
+
+	return &i, nil
+}
+
+// Pending list records not sent yet, oldest first
+func (i *OutboxInspector) Pending(ctx context.Context, f PendingFilter) ([]*OutboxRecord, error) {
+	q := i.collection.Query().
+		Where("sent_at", "=", time.Time{}).
+		Where("created_at", "<=", time.Now().Add(-f.MinAge))
+
+	if f.Topic != "" {
+		q = q.Where("kafka_topic", "=", f.Topic)
+	}
+
+	if f.Limit > 0 {
+		q = q.Limit(f.Limit)
+	}
+
+	iter := q.OrderBy("created_at", docstore.Ascending).Get(ctx)
+	defer iter.Stop()
+
+	records := make([]*OutboxRecord, 0)
+	for {
+		var o OutboxRecord
+		err := iter.Next(ctx, &o)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		records = append(records, &o)
+	}
+
+	return records, nil
+}
+
+// Get get record by ID
+func (i *OutboxInspector) Get(ctx context.Context, id string) (*OutboxRecord, error) {
+	o := &OutboxRecord{ID: id}
+	if err := i.collection.Get(ctx, o); err != nil {
+		return nil, err
+	}
+	return o, nil
+}
+
+// Decode decode event message stored in record
+func (i *OutboxInspector) Decode(ctx context.Context, o *OutboxRecord) (*EventMessage, error) {
+	return i.Config.decodeRecord(ctx, o)
+}
+
+// Delete delete records
+func (i *OutboxInspector) Delete(ctx context.Context, records []*OutboxRecord) error {
+	if len(records) == 0 {
+		return nil
+	}
+
+	deletes := i.collection.Actions()
+	for _, o := range records {
+		deletes.Delete(o)
+	}
+	return deletes.Do(ctx)
+}
+
+// Stats report number, size and age of outbox records with pending records per topic
+func (i *OutboxInspector) Stats(ctx context.Context) (*OutboxReport, error) {
+	return report(ctx, i.collection)
+}
+
+// Close close collection, keeper and claim check bucket
+func (i *OutboxInspector) Close() error {
+	rerr := i.collection.Close()
+	if err := i.Config.Encryption.closeKeeper(); err != nil {
+		rerr = err
+	}
+	if err := i.Config.ClaimCheck.closeBucket(); err != nil {
+		rerr = err
+	}
+	return rerr
+}
@@ -0,0 +1,111 @@
+package event
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sahalazain/go-common/config"
+	_ "github.com/sahalazain/simplecache/mem"
+	"github.com/stretchr/testify/assert"
+	_ "gocloud.dev/docstore/memdocstore"
+	"gocloud.dev/pubsub"
+	_ "gocloud.dev/pubsub/mempubsub"
+)
+
+func TestOutboxInspector(t *testing.T) {
+	cfg := map[string]interface{}{
+		"collection_url": "mem://inspected/_id",
+		"cache_url":      "mem://ic",
+		"pubsub_url":     "mem://$TOPIC",
+		"mark_sent":      true,
+		"config": map[string]interface{}{
+			"compression": map[string]interface{}{"algorithm": "gzip"},
+		},
+	}
+	conf, err := config.Load(cfg, "")
+	assert.Nil(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	out, err := NewOutboxEmitter(ctx, conf)
+	assert.Nil(t, err)
+
+	ins, err := NewOutboxInspector(ctx, conf)
+	assert.Nil(t, err)
+
+	assert.Nil(t, out.Push(ctx, "inspected", "P1", map[string]interface{}{"seq": 1}, nil))
+	time.Sleep(time.Millisecond)
+	assert.Nil(t, out.Push(ctx, "inspected", "P1", map[string]interface{}{"seq": 2}, nil))
+	assert.Nil(t, out.Publish(ctx, "ignored", map[string]interface{}{"seq": 3}, nil))
+
+	records, err := ins.Pending(ctx, PendingFilter{})
+	assert.Nil(t, err)
+	assert.Len(t, records, 3)
+
+	records, err = ins.Pending(ctx, PendingFilter{MinAge: time.Hour})
+	assert.Nil(t, err)
+	assert.Len(t, records, 0)
+
+	records, err = ins.Pending(ctx, PendingFilter{Topic: "inspected", Limit: 1})
+	assert.Nil(t, err)
+	assert.Len(t, records, 1)
+
+	// limited query does not guarantee which records are returned on every driver
+	records, err = ins.Pending(ctx, PendingFilter{Topic: "inspected"})
+	assert.Nil(t, err)
+	assert.Len(t, records, 2)
+
+	data := make([]interface{}, 0, len(records))
+	for _, r := range records {
+		o, err := ins.Get(ctx, r.ID)
+		assert.Nil(t, err)
+		assert.Equal(t, "P1", o.KafkaKey)
+
+		msg, err := ins.Decode(ctx, o)
+		assert.Nil(t, err)
+		data = append(data, msg.Data)
+	}
+	assert.ElementsMatch(t, []interface{}{
+		map[string]interface{}{"seq": float64(1)},
+		map[string]interface{}{"seq": float64(2)},
+	}, data)
+
+	_, err = ins.Get(ctx, "unknown")
+	assert.NotNil(t, err)
+
+	rep, err := ins.Stats(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, rep.Pending)
+	assert.Equal(t, map[string]int{"inspected": 2, "ignored": 1}, rep.Topics)
+
+	// retry records of one topic only
+	_, err = pubsub.OpenTopic(ctx, "mem://inspected")
+	assert.Nil(t, err)
+
+	relay, err := NewOutboxRelay(ctx, conf)
+	assert.Nil(t, err)
+
+	records, err = ins.Pending(ctx, PendingFilter{Topic: "inspected"})
+	assert.Nil(t, err)
+	n, err := relay.Send(ctx, records)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, n)
+
+	records, err = ins.Pending(ctx, PendingFilter{})
+	assert.Nil(t, err)
+	assert.Len(t, records, 1)
+	assert.Equal(t, "ignored", records[0].KafkaTopic)
+
+	assert.Nil(t, ins.Delete(ctx, records))
+	rep, err = ins.Stats(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, rep.Total)
+	assert.Equal(t, 0, rep.Pending)
+
+	_, err = NewOutboxInspector(ctx, config.NewEmbedConfig(map[string]interface{}{}))
+	assert.NotNil(t, err)
+
+	assert.Nil(t, ins.Close())
+}
@@ -0,0 +1,286 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strconv"
+	"sync"
+)
+
+// Envelope event resolved against event config, passed through interceptors before delivery
+type Envelope struct {
+	Event          string                 `json:"event,omitempty" mapstructure:"event"`
+	Topic          string                 `json:"topic,omitempty" mapstructure:"topic"`
+	Key            string                 `json:"key,omitempty" mapstructure:"key"`
+	Sequential     bool                   `json:"sequential,omitempty" mapstructure:"sequential"`
+	Sequence       int64                  `json:"sequence,omitempty" mapstructure:"sequence"`
+	Group          string                 `json:"group,omitempty" mapstructure:"group"`
+	IdempotencyKey string                 `json:"idempotency_key,omitempty" mapstructure:"idempotency_key"`
+	Hash           string                 `json:"hash,omitempty" mapstructure:"hash"`
+	Message        interface{}            `json:"message,omitempty" mapstructure:"message"`
+	Metadata       map[string]interface{} `json:"metadata,omitempty" mapstructure:"metadata"`
+	Headers        map[string]string      `json:"headers,omitempty" mapstructure:"headers"`
+	codec          Codec
+	encoding       string
+	minSize        int
+	encryption     *EncryptionConfig
+	claimCheck     *ClaimCheckConfig
+	previous       chainState
+}
+
+func (e *Envelope) setHeader(k, v string) {
+	if e.Headers == nil {
+		e.Headers = make(map[string]string)
+	}
+	e.Headers[k] = v
+}
+
+// messageMetadata broker message metadata, message key and headers
+func (e *Envelope) messageMetadata() map[string]string {
+	md := make(map[string]string, len(e.Headers)+1)
+	for k, v := range e.Headers {
+		md[k] = v
+	}
+	md["key"] = e.Key
+	return md
+}
+
+func (e *Envelope) toBytes(ctx context.Context) ([]byte, error) {
+	msg := &EventMessage{
+		Data:     e.Message,
+		Metadata: e.Metadata,
+	}
+	if e.codec == nil {
+		return msg.ToBytes()
+	}
+
+	if e.encryption != nil {
+		var err error
+		if msg, err = e.encryption.encryptMessage(ctx, e.codec, msg, e.encoding, e.minSize); err != nil {
+			return nil, err
+		}
+	}
+
+	b, err := e.codec.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	if e.encoding != "" && len(b) >= e.minSize {
+		if b, err = compress(e.encoding, b); err != nil {
+			return nil, err
+		}
+		e.setHeader(contentEncodingHeader, e.encoding)
+	}
+
+	if e.claimCheck == nil || len(b) <= e.claimCheck.Threshold {
+		return b, nil
+	}
+
+	ref, cc, err := e.claimCheck.offload(ctx, e.Topic, b, e.Metadata)
+	if err != nil {
+		return nil, err
+	}
+	e.setHeader(claimCheckHeader, cc.Key)
+	return ref, nil
+}
+
+// binary whether encoded payload is not plain text
+func (e *Envelope) binary() bool {
+	if e.Headers[contentEncodingHeader] != "" || e.encryption != nil {
+		return true
+	}
+	return e.codec != nil && e.codec.ContentType() != jsonCodec{}.ContentType()
+}
+
+// SendFunc deliver resolved event
+type SendFunc func(ctx context.Context, env *Envelope) error
+
+// Interceptor wrap event delivery for cross cutting concern such as enrichment, validation, tracing or metrics.
+// Interceptor may modify envelope before calling next, returning without calling next drops the event with returned error.
+type Interceptor func(next SendFunc) SendFunc
+
+// Option emitter option
+type Option func(o *options)
+
+type options struct {
+	interceptors []Interceptor
+	sender       SendFunc
+}
+
+// WithInterceptor add interceptors, the first one is the outermost after built-in tracing.
+// Configured header forwarding always runs innermost
+func WithInterceptor(interceptors ...Interceptor) Option {
+	return func(o *options) {
+		o.interceptors = append(o.interceptors, interceptors...)
+	}
+}
+
+// WithSender replace broker delivery of pubsub emitter with send, which receives envelopes before encoding.
+// Chains and idempotency keys are still tracked, e.g. to record emitted events in tests
+func WithSender(send SendFunc) Option {
+	return func(o *options) {
+		o.sender = send
+	}
+}
+
+func newOptions(c *EventConfig, opts []Option) *options {
+	o := &options{
+		interceptors: []Interceptor{tracing},
+	}
+	for _, opt := range opts {
+		opt(o)
+	}
+	// headers are forwarded last so they reflect metadata changed by interceptors
+	if c.Headers.enabled() {
+		o.interceptors = append(o.interceptors, c.Headers.forward)
+	}
+	return o
+}
+
+var errNextCalled = errors.New("[Emitter] interceptor called next more than once")
+
+// intercept run envelopes through interceptors, envelopes of the same group one after another in batch order and
+// groups concurrently. Envelopes are delivered as soon as their chain calls next, with context passed to next.
+// Those arriving with the same context while a delivery runs are delivered together in the next deliver call, so
+// an interceptor holding a lock around next does not block the rest of the batch. Each interceptor sees its own
+// delivery result. Once an envelope of a group fails the rest of the group is not sent, keeping its order
+func intercept(ctx context.Context, interceptors []Interceptor, envs []*Envelope, errs BatchError, deliver func(ctx context.Context, envs []*Envelope) BatchError) {
+	if len(interceptors) == 0 {
+		for i, err := range deliver(ctx, envs) {
+			errs[i] = err
+		}
+		return
+	}
+
+	groups := make(map[string][]int)
+	order := make([]string, 0)
+	for i, env := range envs {
+		if env == nil {
+			continue
+		}
+		g := env.Group
+		if g == "" {
+			// ungrouped envelope is independent
+			g = "#" + strconv.Itoa(i)
+		}
+		if _, ok := groups[g]; !ok {
+			order = append(order, g)
+		}
+		groups[g] = append(groups[g], i)
+	}
+
+	b := &batcher{deliver: deliver}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, g := range order {
+		wg.Add(1)
+		go func(idx []int) {
+			defer wg.Done()
+			for n, i := range idx {
+				err := b.intercept(ctx, interceptors, envs[i])
+				if err == nil {
+					continue
+				}
+
+				mu.Lock()
+				errs[i] = err
+				for _, j := range idx[n+1:] {
+					errs[j] = err
+				}
+				mu.Unlock()
+				return
+			}
+		}(groups[g])
+	}
+	wg.Wait()
+}
+
+// batcher deliver envelopes reaching the end of interceptor chains, one deliver call at a time
+type batcher struct {
+	deliver func(ctx context.Context, envs []*Envelope) BatchError
+	mu      sync.Mutex
+	queue   []*delivery
+	running bool
+}
+
+type delivery struct {
+	ctx  context.Context
+	env  *Envelope
+	done chan error
+}
+
+// intercept run envelope through interceptors, returns error of interceptor or delivery
+func (b *batcher) intercept(ctx context.Context, interceptors []Interceptor, env *Envelope) error {
+	var once sync.Once
+	send := SendFunc(func(ctx context.Context, e *Envelope) error {
+		called := false
+		once.Do(func() { called = true })
+		if !called {
+			return errNextCalled
+		}
+		return b.send(ctx, e)
+	})
+
+	for n := len(interceptors) - 1; n >= 0; n-- {
+		send = interceptors[n](send)
+	}
+	return send(ctx, env)
+}
+
+// send queue envelope and wait for its delivery result, delivery runs in given context
+func (b *batcher) send(ctx context.Context, env *Envelope) error {
+	d := &delivery{ctx: ctx, env: env, done: make(chan error, 1)}
+
+	b.mu.Lock()
+	b.queue = append(b.queue, d)
+	if !b.running {
+		b.running = true
+		go b.run()
+	}
+	b.mu.Unlock()
+
+	return <-d.done
+}
+
+// run deliver queued envelopes until queue is empty, consecutive envelopes sharing context in one deliver call
+func (b *batcher) run() {
+	for {
+		b.mu.Lock()
+		queue := b.queue
+		b.queue = nil
+		if len(queue) == 0 {
+			b.running = false
+			b.mu.Unlock()
+			return
+		}
+		b.mu.Unlock()
+
+		for len(queue) > 0 {
+			n := 1
+			for n < len(queue) && sameContext(queue[0].ctx, queue[n].ctx) {
+				n++
+			}
+
+			envs := make([]*Envelope, n)
+			for i, d := range queue[:n] {
+				envs[i] = d.env
+			}
+
+			errs := b.deliver(queue[0].ctx, envs)
+			for i, d := range queue[:n] {
+				d.done <- errs[i]
+			}
+			queue = queue[n:]
+		}
+	}
+}
+
+// sameContext whether contexts are the same value, contexts of non comparable type never are
+func sameContext(a, b context.Context) bool {
+	if !reflect.TypeOf(a).Comparable() {
+		return false
+	}
+	return a == b
+}
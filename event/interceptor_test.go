@@ -0,0 +1,191 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sahalazain/go-common/config"
+	_ "github.com/sahalazain/simplecache/mem"
+	"github.com/stretchr/testify/assert"
+	"gocloud.dev/docstore"
+	_ "gocloud.dev/docstore/memdocstore"
+)
+
+func TestInterceptor(t *testing.T) {
+	cfg := map[string]interface{}{
+		"type":           "outbox",
+		"collection_url": "mem://intercepted/_id",
+		"cache_url":      "mem://ic",
+		"config": map[string]interface{}{
+			"event_map": map[string]interface{}{"created": "parcel"},
+		},
+	}
+	conf, err := config.Load(cfg, "")
+	assert.Nil(t, err)
+
+	ctx := context.Background()
+
+	var mu sync.Mutex
+	var seen []*Envelope
+	var results []error
+
+	record := func(next SendFunc) SendFunc {
+		return func(ctx context.Context, env *Envelope) error {
+			mu.Lock()
+			seen = append(seen, env)
+			mu.Unlock()
+
+			err := next(ctx, env)
+
+			mu.Lock()
+			results = append(results, err)
+			mu.Unlock()
+			return err
+		}
+	}
+
+	enrich := func(next SendFunc) SendFunc {
+		return func(ctx context.Context, env *Envelope) error {
+			env.Metadata["source"] = "tracking"
+			return next(ctx, env)
+		}
+	}
+
+	validate := func(next SendFunc) SendFunc {
+		return func(ctx context.Context, env *Envelope) error {
+			if env.Message == nil {
+				return errors.New("empty event")
+			}
+			return next(ctx, env)
+		}
+	}
+
+	em, err := NewEmitter(ctx, conf, WithInterceptor(record, enrich), WithInterceptor(validate))
+	assert.Nil(t, err)
+
+	err = em.PublishBatch(ctx, []Event{
+		{Name: "created", Key: "P1", Message: map[string]interface{}{"status": "created"}},
+		{Name: "created", Key: "P2", Message: nil},
+	})
+	berr, ok := err.(BatchError)
+	assert.True(t, ok)
+	assert.Len(t, berr, 1)
+	assert.EqualError(t, berr[1], "empty event")
+
+	assert.Len(t, seen, 2)
+	for _, env := range seen {
+		assert.Equal(t, "created", env.Event)
+		assert.Equal(t, "parcel", env.Topic)
+		assert.True(t, env.Sequential)
+		assert.NotEmpty(t, env.Metadata["hash"])
+	}
+
+	col, err := docstore.OpenCollection(ctx, "mem://intercepted/_id")
+	assert.Nil(t, err)
+
+	iter := col.Query().Get(ctx)
+	defer iter.Stop()
+
+	var o OutboxRecord
+	assert.Nil(t, iter.Next(ctx, &o))
+	assert.Equal(t, "P1", o.KafkaKey)
+	assert.Contains(t, o.KafkaValue, `"source":"tracking"`)
+	assert.Equal(t, io.EOF, iter.Next(ctx, &o))
+}
+
+func TestInterceptorLocking(t *testing.T) {
+	cfg := map[string]interface{}{
+		"type":           "outbox",
+		"collection_url": "mem://interceptlock/_id",
+		"cache_url":      "mem://ilc",
+	}
+	conf, err := config.Load(cfg, "")
+	assert.Nil(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	// interceptor with shared state held across next, e.g. metrics or rate limiting
+	var mu sync.Mutex
+	order := make([]interface{}, 0)
+	locking := func(next SendFunc) SendFunc {
+		return func(ctx context.Context, env *Envelope) error {
+			mu.Lock()
+			defer mu.Unlock()
+			order = append(order, env.Message)
+			return next(ctx, env)
+		}
+	}
+
+	em, err := NewEmitter(ctx, conf, WithInterceptor(locking))
+	assert.Nil(t, err)
+
+	events := make([]Event, 0)
+	for i := 0; i < 5; i++ {
+		events = append(events, Event{Name: "locked", Key: "P1", Message: i}, Event{Name: "locked", Message: i + 10})
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- em.PublishBatch(ctx, events)
+	}()
+
+	select {
+	case err := <-done:
+		assert.Nil(t, err)
+	case <-ctx.Done():
+		t.Fatal("batch blocked by locking interceptor")
+	}
+
+	// events of one key are intercepted in batch order
+	keyed := make([]interface{}, 0)
+	for _, m := range order {
+		if m.(int) < 10 {
+			keyed = append(keyed, m)
+		}
+	}
+	assert.Equal(t, []interface{}{0, 1, 2, 3, 4}, keyed)
+	assert.Len(t, order, 10)
+}
+
+type tenantKey struct{}
+
+func TestInterceptorContext(t *testing.T) {
+	conf, err := config.Load(map[string]interface{}{"cache_url": "mem://itc"}, "")
+	assert.Nil(t, err)
+
+	ctx := context.Background()
+
+	tenant := func(next SendFunc) SendFunc {
+		return func(ctx context.Context, env *Envelope) error {
+			ctx, cancel := context.WithTimeout(context.WithValue(ctx, tenantKey{}, env.Key), time.Minute)
+			defer cancel()
+			return next(ctx, env)
+		}
+	}
+
+	var mu sync.Mutex
+	tenants := make(map[string]interface{})
+	ps, err := NewPubSubEmitter(ctx, conf, WithInterceptor(tenant), WithSender(func(ctx context.Context, env *Envelope) error {
+		_, deadline := ctx.Deadline()
+		assert.True(t, deadline)
+
+		mu.Lock()
+		tenants[env.Key] = ctx.Value(tenantKey{})
+		mu.Unlock()
+		return nil
+	}))
+	assert.Nil(t, err)
+
+	assert.Nil(t, ps.PublishBatch(ctx, []Event{
+		{Name: "tenant", Key: "T1", Message: "picked"},
+		{Name: "tenant", Key: "T2", Message: "picked"},
+	}))
+	assert.Equal(t, map[string]interface{}{"T1": "T1", "T2": "T2"}, tenants)
+
+	assert.Nil(t, ps.Close(ctx))
+}
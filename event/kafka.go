@@ -0,0 +1,212 @@
+package event
+
+import (
+	"crypto/sha512"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/xdg-go/scram"
+	"gocloud.dev/pubsub/kafkapubsub"
+)
+
+// kafkaKeyName metadata entry used as kafka message key, emitters always set it from event key
+const kafkaKeyName = "key"
+
+// KafkaConfig kafka client configuration, mapped onto sarama config
+type KafkaConfig struct {
+	ClientID        string        `json:"client_id,omitempty" mapstructure:"client_id"`
+	Version         string        `json:"version,omitempty" mapstructure:"version"`
+	Acks            string        `json:"acks,omitempty" mapstructure:"acks"`
+	Compression     string        `json:"compression,omitempty" mapstructure:"compression"`
+	Idempotent      bool          `json:"idempotent,omitempty" mapstructure:"idempotent"`
+	MaxMessageBytes int           `json:"max_message_bytes,omitempty" mapstructure:"max_message_bytes"`
+	Retries         int           `json:"retries,omitempty" mapstructure:"retries"`
+	Timeout         time.Duration `json:"timeout,omitempty" mapstructure:"timeout"`
+	TLS             KafkaTLS      `json:"tls,omitempty" mapstructure:"tls"`
+	SASL            KafkaSASL     `json:"sasl,omitempty" mapstructure:"sasl"`
+}
+
+// KafkaTLS kafka TLS configuration
+type KafkaTLS struct {
+	Enable             bool   `json:"enable,omitempty" mapstructure:"enable"`
+	CAFile             string `json:"ca_file,omitempty" mapstructure:"ca_file"`
+	CertFile           string `json:"cert_file,omitempty" mapstructure:"cert_file"`
+	KeyFile            string `json:"key_file,omitempty" mapstructure:"key_file"`
+	InsecureSkipVerify bool   `json:"insecure_skip_verify,omitempty" mapstructure:"insecure_skip_verify"`
+}
+
+// KafkaSASL kafka SASL configuration
+type KafkaSASL struct {
+	Enable    bool   `json:"enable,omitempty" mapstructure:"enable"`
+	Mechanism string `json:"mechanism,omitempty" mapstructure:"mechanism"`
+	User      string `json:"user,omitempty" mapstructure:"user"`
+	Password  string `json:"password,omitempty" mapstructure:"password"`
+}
+
+// saramaConfig build and validate sarama config on top of kafkapubsub minimal config
+func (k *KafkaConfig) saramaConfig() (*sarama.Config, error) {
+	c := kafkapubsub.MinimalConfig()
+
+	if k.ClientID != "" {
+		c.ClientID = k.ClientID
+	}
+
+	if k.Version != "" {
+		v, err := sarama.ParseKafkaVersion(k.Version)
+		if err != nil {
+			return nil, err
+		}
+		c.Version = v
+	}
+
+	switch strings.ToLower(k.Acks) {
+	case "":
+	case "all", "-1":
+		c.Producer.RequiredAcks = sarama.WaitForAll
+	case "local", "leader", "1":
+		c.Producer.RequiredAcks = sarama.WaitForLocal
+	case "none", "0":
+		c.Producer.RequiredAcks = sarama.NoResponse
+	default:
+		return nil, fmt.Errorf("[Kafka] unsupported acks %q", k.Acks)
+	}
+
+	switch strings.ToLower(k.Compression) {
+	case "", "none":
+		c.Producer.Compression = sarama.CompressionNone
+	case "gzip":
+		c.Producer.Compression = sarama.CompressionGZIP
+	case "snappy":
+		c.Producer.Compression = sarama.CompressionSnappy
+	case "lz4":
+		c.Producer.Compression = sarama.CompressionLZ4
+	case "zstd":
+		c.Producer.Compression = sarama.CompressionZSTD
+	default:
+		return nil, fmt.Errorf("[Kafka] unsupported compression %q", k.Compression)
+	}
+
+	if k.Idempotent {
+		c.Producer.Idempotent = true
+		c.Net.MaxOpenRequests = 1
+		if k.Acks == "" {
+			c.Producer.RequiredAcks = sarama.WaitForAll
+		}
+	}
+
+	if k.MaxMessageBytes > 0 {
+		c.Producer.MaxMessageBytes = k.MaxMessageBytes
+	}
+
+	if k.Retries > 0 {
+		c.Producer.Retry.Max = k.Retries
+	}
+
+	if k.Timeout > 0 {
+		c.Producer.Timeout = k.Timeout
+		c.Net.DialTimeout = k.Timeout
+	}
+
+	if k.TLS.Enable {
+		tc, err := k.TLS.tlsConfig()
+		if err != nil {
+			return nil, err
+		}
+		c.Net.TLS.Enable = true
+		c.Net.TLS.Config = tc
+	}
+
+	if k.SASL.Enable {
+		if err := k.SASL.apply(c); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+
+	return c, nil
+}
+
+func (t *KafkaTLS) tlsConfig() (*tls.Config, error) {
+	tc := &tls.Config{
+		InsecureSkipVerify: t.InsecureSkipVerify,
+	}
+
+	if t.CAFile != "" {
+		ca, err := ioutil.ReadFile(t.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, errors.New("[Kafka] invalid tls ca_file")
+		}
+		tc.RootCAs = pool
+	}
+
+	if t.CertFile != "" || t.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(t.CertFile, t.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		tc.Certificates = []tls.Certificate{cert}
+	}
+
+	return tc, nil
+}
+
+func (s *KafkaSASL) apply(c *sarama.Config) error {
+	c.Net.SASL.Enable = true
+	c.Net.SASL.User = s.User
+	c.Net.SASL.Password = s.Password
+
+	switch strings.ToUpper(s.Mechanism) {
+	case "", sarama.SASLTypePlaintext:
+		c.Net.SASL.Mechanism = sarama.SASLTypePlaintext
+	case sarama.SASLTypeSCRAMSHA256:
+		c.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA256
+		c.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+			return &scramClient{hash: scram.SHA256}
+		}
+	case sarama.SASLTypeSCRAMSHA512:
+		c.Net.SASL.Mechanism = sarama.SASLTypeSCRAMSHA512
+		c.Net.SASL.SCRAMClientGeneratorFunc = func() sarama.SCRAMClient {
+			return &scramClient{hash: scram.HashGeneratorFcn(sha512.New)}
+		}
+	default:
+		return fmt.Errorf("[Kafka] unsupported sasl mechanism %q", s.Mechanism)
+	}
+
+	return nil
+}
+
+// scramClient sarama SCRAM client backed by xdg-go/scram
+type scramClient struct {
+	hash scram.HashGeneratorFcn
+	conv *scram.ClientConversation
+}
+
+func (s *scramClient) Begin(userName, password, authzID string) error {
+	client, err := s.hash.NewClient(userName, password, authzID)
+	if err != nil {
+		return err
+	}
+	s.conv = client.NewConversation()
+	return nil
+}
+
+func (s *scramClient) Step(challenge string) (string, error) {
+	return s.conv.Step(challenge)
+}
+
+func (s *scramClient) Done() bool {
+	return s.conv.Done()
+}
@@ -0,0 +1,72 @@
+package event
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/Shopify/sarama"
+	"github.com/sahalazain/go-common/config"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKafkaConfig(t *testing.T) {
+	cfg := map[string]interface{}{
+		"cache_url":  "mem://kfc",
+		"pubsub_url": "kafka://$TOPIC",
+		"kafka": map[string]interface{}{
+			"client_id":         "emitter",
+			"version":           "2.1.0",
+			"compression":       "zstd",
+			"idempotent":        true,
+			"max_message_bytes": 2048,
+			"retries":           5,
+			"timeout":           "3s",
+			"sasl": map[string]interface{}{
+				"enable":    true,
+				"mechanism": "SCRAM-SHA-512",
+				"user":      "user",
+				"password":  "secret",
+			},
+		},
+	}
+	conf, err := config.Load(cfg, "")
+	assert.Nil(t, err)
+
+	ps, err := NewPubSubEmitter(context.Background(), conf)
+	assert.Nil(t, err)
+
+	kc, err := ps.Kafka.saramaConfig()
+	assert.Nil(t, err)
+	assert.Equal(t, "emitter", kc.ClientID)
+	assert.Equal(t, sarama.V2_1_0_0, kc.Version)
+	assert.Equal(t, sarama.CompressionZSTD, kc.Producer.Compression)
+	assert.True(t, kc.Producer.Idempotent)
+	assert.Equal(t, sarama.WaitForAll, kc.Producer.RequiredAcks)
+	assert.Equal(t, 1, kc.Net.MaxOpenRequests)
+	assert.Equal(t, 2048, kc.Producer.MaxMessageBytes)
+	assert.Equal(t, 5, kc.Producer.Retry.Max)
+	assert.Equal(t, 3*time.Second, kc.Producer.Timeout)
+	assert.Equal(t, sarama.SASLMechanism(sarama.SASLTypeSCRAMSHA512), kc.Net.SASL.Mechanism)
+	assert.NotNil(t, kc.Net.SASL.SCRAMClientGeneratorFunc())
+
+	invalid := []KafkaConfig{
+		{Acks: "some"},
+		{Compression: "brotli"},
+		{Version: "x.y"},
+		{Idempotent: true, Acks: "none"},
+		{SASL: KafkaSASL{Enable: true, Mechanism: "GSSAPI"}},
+		{TLS: KafkaTLS{Enable: true, CAFile: "missing.pem"}},
+	}
+	for _, k := range invalid {
+		_, err := k.saramaConfig()
+		assert.NotNil(t, err, "%+v", k)
+	}
+
+	cfg["kafka"] = map[string]interface{}{"acks": "some"}
+	conf, err = config.Load(cfg, "")
+	assert.Nil(t, err)
+
+	_, err = NewPubSubEmitter(context.Background(), conf)
+	assert.NotNil(t, err)
+}
@@ -5,16 +5,30 @@ import (
 	"encoding/base64"
 	"fmt"
 	"time"
+
+	"gocloud.dev/pubsub"
 )
 
+const valueEncodingBase64 = "base64"
+
 //OutboxRecord outbox model
 type OutboxRecord struct {
-	ID         string    `json:"_id,omitempty" mapstructure:"_id" docstore:"_id"`
-	GroupID    string    `json:"group_id,omitempty" mapstructure:"group_id" docstore:"group_id"`
-	KafkaTopic string    `json:"kafka_topic,omitempty" mapstructure:"kafka_topic" docstore:"kafka_topic"`
-	KafkaKey   string    `json:"kafka_key,omitempty" mapstructure:"kafka_key" docstore:"kafka_key"`
-	KafkaValue string    `json:"kafka_value,omitempty" mapstructure:"kafka_value" docstore:"kafka_value"`
-	CreatedAt  time.Time `json:"created_at,omitempty" mapstructure:"created_at" docstore:"created_at"`
+	ID            string            `json:"_id,omitempty" mapstructure:"_id" docstore:"_id"`
+	GroupID       string            `json:"group_id,omitempty" mapstructure:"group_id" docstore:"group_id"`
+	KafkaTopic    string            `json:"kafka_topic,omitempty" mapstructure:"kafka_topic" docstore:"kafka_topic"`
+	KafkaKey      string            `json:"kafka_key,omitempty" mapstructure:"kafka_key" docstore:"kafka_key"`
+	KafkaValue    string            `json:"kafka_value,omitempty" mapstructure:"kafka_value" docstore:"kafka_value"`
+	CreatedAt     time.Time         `json:"created_at,omitempty" mapstructure:"created_at" docstore:"created_at"`
+	SentAt        time.Time         `json:"sent_at,omitempty" mapstructure:"sent_at" docstore:"sent_at"`
+	Headers       map[string]string `json:"headers,omitempty" mapstructure:"headers" docstore:"headers"`
+	ValueEncoding string            `json:"value_encoding,omitempty" mapstructure:"value_encoding" docstore:"value_encoding"`
+	Status        string            `json:"status,omitempty" mapstructure:"status" docstore:"status"`
+	Attempts      int               `json:"attempts,omitempty" mapstructure:"attempts" docstore:"attempts"`
+	LastError     string            `json:"last_error,omitempty" mapstructure:"last_error" docstore:"last_error"`
+	FailedAt      time.Time         `json:"failed_at,omitempty" mapstructure:"failed_at" docstore:"failed_at"`
+	ClaimedAt     time.Time         `json:"claimed_at,omitempty" mapstructure:"claimed_at" docstore:"claimed_at"`
+	// Revision document revision set by docstore, updates of record read with revision fail when it changed meanwhile
+	Revision interface{} `json:"-" mapstructure:"-" docstore:"DocstoreRevision"`
 }
 
 //Hash calculate request hash
@@ -30,3 +44,52 @@ func (o *OutboxRecord) GenerateID() *OutboxRecord {
 	o.ID = base64.StdEncoding.EncodeToString(h[:])
 	return o
 }
+
+func (o *OutboxRecord) documentID() string {
+	return o.ID
+}
+
+func (o *OutboxRecord) createdAt() time.Time {
+	return o.CreatedAt
+}
+
+func (o *OutboxRecord) setCreatedAt(t time.Time) {
+	o.CreatedAt = t
+}
+
+// setValue store message body, binary body is stored as base64
+func (o *OutboxRecord) setValue(b []byte, binary bool) {
+	if binary {
+		o.KafkaValue = base64.StdEncoding.EncodeToString(b)
+		o.ValueEncoding = valueEncodingBase64
+		return
+	}
+	o.KafkaValue = string(b)
+	o.ValueEncoding = ""
+}
+
+// value return stored message body
+func (o *OutboxRecord) value() ([]byte, error) {
+	if o.ValueEncoding == valueEncodingBase64 {
+		return base64.StdEncoding.DecodeString(o.KafkaValue)
+	}
+	return []byte(o.KafkaValue), nil
+}
+
+func (o *OutboxRecord) toMessage() (*pubsub.Message, error) {
+	body, err := o.value()
+	if err != nil {
+		return nil, err
+	}
+
+	md := make(map[string]string, len(o.Headers)+1)
+	for k, v := range o.Headers {
+		md[k] = v
+	}
+	md["key"] = o.KafkaKey
+
+	return &pubsub.Message{
+		Body:     body,
+		Metadata: md,
+	}, nil
+}
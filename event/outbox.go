@@ -3,25 +3,27 @@ package event
 import (
 	"context"
 	"errors"
-	"time"
+	"sync"
 
-	"github.com/imdario/mergo"
 	"github.com/sahalazain/go-common/config"
 	"gocloud.dev/docstore"
-	"gocloud.dev/gcerrors"
 )
 
-//Outbox outbox repository
+//Outbox outbox repository, records are stored in default or debezium record layout
 type Outbox struct {
 	CollectionURL string      `json:"collection_url,omitempty" mapstructure:"collection_url"`
 	CacheURL      string      `json:"cache_url,omitempty" mapstructure:"cache_url"`
 	Config        EventConfig `json:"config,omitempty" mapstructure:"config"`
+	RecordLayout  string      `json:"record_layout,omitempty" mapstructure:"record_layout"`
 	collection    *docstore.Collection
 	ec            *EmitterCache
+	mu            sync.RWMutex
+	closed        bool
+	interceptors  []Interceptor
 }
 
 //NewOutboxEmitter create outbox emitter instance
-func NewOutboxEmitter(ctx context.Context, conf config.Getter) (*Outbox, error) {
+func NewOutboxEmitter(ctx context.Context, conf config.Getter, opts ...Option) (*Outbox, error) {
 
 	var ob Outbox
 	if err := conf.Unmarshal(&ob); err != nil {
@@ -36,6 +38,18 @@ func NewOutboxEmitter(ctx context.Context, conf config.Getter) (*Outbox, error)
 		return nil, errors.New("missing cache_url param")
 	}
 
+	if err := validLayout(ob.RecordLayout); err != nil {
+		return nil, err
+	}
+
+	if err := ob.Config.Encryption.openKeeper(ctx); err != nil {
+		return nil, err
+	}
+
+	if err := ob.Config.ClaimCheck.openBucket(ctx); err != nil {
+		return nil, err
+	}
+
 	col, err := docstore.OpenCollection(ctx, ob.CollectionURL)
 	if err != nil {
 		return nil, err
@@ -50,6 +64,8 @@ func NewOutboxEmitter(ctx context.Context, conf config.Getter) (*Outbox, error)
 
 	ob.ec = ec
 
+	ob.interceptors = newOptions(&ob.Config, opts).interceptors
+
 	return &ob, nil
 }
 
@@ -59,72 +75,88 @@ func (o *Outbox) Publish(ctx context.Context, event string, message interface{},
 }
 
 func (o *Outbox) send(ctx context.Context, event, key string, message interface{}, metadata map[string]interface{}) error {
-	event = o.Config.getTopic(event)
-	md := o.Config.getMetadata(event)
+	return errorAt(o.PublishBatch(ctx, []Event{{Name: event, Key: key, Message: message, Metadata: metadata}}), 0)
+}
 
-	if metadata != nil {
-		if err := mergo.Merge(&md, metadata); err != nil {
-			return err
-		}
-	}
+// PublishBatch store batch of events to outbox using bulk actions, failed entries are reported as BatchError
+func (o *Outbox) PublishBatch(ctx context.Context, events []Event) error {
+	o.mu.RLock()
+	closed := o.closed
+	o.mu.RUnlock()
 
-	mhash, err := hash(message)
-	if err != nil {
-		return err
+	if closed {
+		return ErrEmitterClosed
 	}
 
-	md["hash"] = mhash
-
-	seq := false
+	envs, errs, unlock := o.Config.resolve(ctx, o.ec, events)
+	defer unlock()
 
-	if key == "" {
-		key = mhash
-	} else {
-		seq = true
-		md["previous"] = o.ec.getPrevious(ctx, event+key)
-	}
+	intercept(ctx, o.interceptors, envs, errs, o.store)
 
-	msg := &EventMessage{
-		Data:     message,
-		Metadata: md,
+	if len(errs) > 0 {
+		return errs
 	}
+	return nil
+}
 
-	b, err := msg.ToBytes()
+func (o *Outbox) store(ctx context.Context, envs []*Envelope) BatchError {
+	errs := make(BatchError)
+	records, idx := toRecords(ctx, envs, errs)
+	docs, err := o.documents(envs, records, idx)
 	if err != nil {
-		return err
-	}
-
-	ob := (&OutboxRecord{
-		KafkaKey:   key,
-		KafkaTopic: event,
-		KafkaValue: string(b),
-	}).GenerateID()
-
-	if err := o.collection.Get(ctx, ob); err != nil {
-		code := gcerrors.Code(err)
-		if code != gcerrors.NotFound {
-			return err
+		for _, i := range idx {
+			errs[i] = err
 		}
+		return errs
 	}
+	serrs, created := storeRecords(ctx, o.collection, docs)
 
-	if !ob.CreatedAt.IsZero() {
-		return nil
-	}
-
-	ob.CreatedAt = time.Now()
-
-	if err := o.collection.Create(ctx, ob); err != nil {
-		return err
-	}
+	for j, i := range idx {
+		if err := serrs[j]; err != nil {
+			errs[i] = err
+			continue
+		}
+		o.ec.remember(ctx, envs[i], o.Config.dedupeWindow())
 
-	if seq {
-		o.ec.setCurrent(ctx, event+key, mhash)
+		if created[j] {
+			if err := o.ec.advanceChain(ctx, envs[i]); err != nil {
+				errs[i] = err
+			}
+		}
 	}
 
-	return nil
+	return errs
 }
 
 // Push publish sequential event
 func (o *Outbox) Push(ctx context.Context, event, key string, message interface{}, metadata map[string]interface{}) error {
 	return o.send(ctx, event, key, message, metadata)
 }
+
+// Flush records are stored synchronously, nothing to flush
+func (o *Outbox) Flush(ctx context.Context) error {
+	return nil
+}
+
+// Close close outbox collection and cache
+func (o *Outbox) Close(ctx context.Context) error {
+	o.mu.Lock()
+	if o.closed {
+		o.mu.Unlock()
+		return nil
+	}
+	o.closed = true
+	o.mu.Unlock()
+
+	cerr := o.ec.Close()
+	if err := o.Config.Encryption.closeKeeper(); err != nil {
+		cerr = err
+	}
+	if err := o.Config.ClaimCheck.closeBucket(); err != nil {
+		cerr = err
+	}
+	if err := o.collection.Close(); err != nil {
+		return err
+	}
+	return cerr
+}
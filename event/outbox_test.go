@@ -36,3 +36,22 @@ func TestOutbox(t *testing.T) {
 	err = out.Publish(ctx, "test", obj, nil)
 	assert.Nil(t, err)
 }
+
+func TestOutboxClose(t *testing.T) {
+	cfg := map[string]interface{}{
+		"collection_url": "mem://outboxclose/_id",
+		"cache_url":      "mem://occ",
+	}
+	conf, err := config.Load(cfg, "")
+	assert.Nil(t, err)
+
+	ctx := context.Background()
+
+	out, err := NewOutboxEmitter(ctx, conf)
+	assert.Nil(t, err)
+
+	assert.Nil(t, out.Flush(ctx))
+	assert.Nil(t, out.Close(ctx))
+	assert.Nil(t, out.Close(ctx))
+	assert.Equal(t, ErrEmitterClosed, out.Publish(ctx, "test", map[string]interface{}{"name": "SiCepat"}, nil))
+}
@@ -3,37 +3,54 @@ package event
 import (
 	"context"
 	"errors"
-	"net/url"
-	"strings"
+	"sync"
 
-	"github.com/imdario/mergo"
 	"github.com/sahalazain/go-common/config"
 	"gocloud.dev/pubsub"
-	"gocloud.dev/pubsub/kafkapubsub"
 )
 
 //PubSub pubsub event emitter
 type PubSub struct {
-	Config      EventConfig `json:"config,omitempty" mapstructure:"config"`
-	CacheURL    string      `json:"cache_url,omitempty" mapstructure:"cache_url"`
-	PubsubURL   string      `json:"pubsub_url,omitempty" mapstructure:"pubsub_url"`
-	KafkaBroker string      `json:"kafka_broker,omitempty" mapstructure:"kafka_broker"`
-	topics      map[string]*pubsub.Topic
-	ec          *EmitterCache
+	Config       EventConfig `json:"config,omitempty" mapstructure:"config"`
+	CacheURL     string      `json:"cache_url,omitempty" mapstructure:"cache_url"`
+	PubsubURL    string      `json:"pubsub_url,omitempty" mapstructure:"pubsub_url"`
+	KafkaBroker  string      `json:"kafka_broker,omitempty" mapstructure:"kafka_broker"`
+	Kafka        KafkaConfig `json:"kafka,omitempty" mapstructure:"kafka"`
+	topics       *topicCache
+	ec           *EmitterCache
+	mu           sync.RWMutex
+	closed       bool
+	publishing   sync.WaitGroup
+	interceptors []Interceptor
+	sender       SendFunc
 }
 
 //NewPubSubEmitter create instance of pubsub emitter
-func NewPubSubEmitter(ctx context.Context, conf config.Getter) (*PubSub, error) {
+func NewPubSubEmitter(ctx context.Context, conf config.Getter, opts ...Option) (*PubSub, error) {
 	var ps PubSub
 
 	if err := conf.Unmarshal(&ps); err != nil {
 		return nil, err
 	}
 
-	if ps.PubsubURL == "" {
+	o := newOptions(&ps.Config, opts)
+	if ps.PubsubURL == "" && o.sender == nil {
 		return nil, errors.New("missing pubsub_url param")
 	}
 
+	kc, err := ps.Kafka.saramaConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ps.Config.Encryption.openKeeper(ctx); err != nil {
+		return nil, err
+	}
+
+	if err := ps.Config.ClaimCheck.openBucket(ctx); err != nil {
+		return nil, err
+	}
+
 	ec, err := NewEmitterCache(ps.CacheURL)
 	if err != nil {
 		return nil, err
@@ -41,7 +58,10 @@ func NewPubSubEmitter(ctx context.Context, conf config.Getter) (*PubSub, error)
 
 	ps.ec = ec
 
-	ps.topics = make(map[string]*pubsub.Topic)
+	ps.topics = newTopicCache(ps.PubsubURL, ps.KafkaBroker, kc)
+	ps.interceptors = o.interceptors
+	ps.sender = o.sender
+
 	return &ps, nil
 }
 
@@ -56,96 +76,153 @@ func (p *PubSub) Push(ctx context.Context, event string, key string, message int
 }
 
 func (p *PubSub) send(ctx context.Context, event, key string, message interface{}, metadata map[string]interface{}) error {
+	return errorAt(p.PublishBatch(ctx, []Event{{Name: event, Key: key, Message: message, Metadata: metadata}}), 0)
+}
+
+// PublishBatch send batch of events concurrently so topic can batch them, events sharing key are sent in order.
+// Failed entries are reported as BatchError
+func (p *PubSub) PublishBatch(ctx context.Context, events []Event) error {
+	p.mu.RLock()
+	if p.closed {
+		p.mu.RUnlock()
+		return ErrEmitterClosed
+	}
+	p.publishing.Add(1)
+	p.mu.RUnlock()
+	defer p.publishing.Done()
+
 	if p.topics == nil {
 		return errors.New("pubsub is not configured")
 	}
 
-	event = p.Config.getTopic(event)
+	envs, errs, unlock := p.Config.resolve(ctx, p.ec, events)
+	defer unlock()
 
-	if _, ok := p.topics[event]; !ok {
-
-		to := strings.ReplaceAll(p.PubsubURL, "$TOPIC", event)
-
-		u, err := url.Parse(to)
-		if err != nil {
-			return err
-		}
+	intercept(ctx, p.interceptors, envs, errs, p.deliverAll)
 
-		if u.Scheme == "kafka" {
-			brokers := p.KafkaBroker
-			if strings.Contains(u.Host, ":") {
-				brokers = u.Host
-			}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
 
-			if brokers == "" {
-				return errors.New("missing kafka broker")
-			}
+func (p *PubSub) deliverAll(ctx context.Context, envs []*Envelope) BatchError {
+	errs := make(BatchError)
 
-			topic, err := kafkapubsub.OpenTopic(strings.Split(brokers, ","), kafkapubsub.MinimalConfig(), event, &kafkapubsub.TopicOptions{KeyName: "key"})
-			if err != nil {
-				return err
-			}
-			p.topics[event] = topic
-		} else {
-			topic, err := pubsub.OpenTopic(ctx, to)
-			if err != nil {
-				return err
-			}
-			p.topics[event] = topic
+	groups := make(map[string][]int)
+	for i, env := range envs {
+		if env == nil {
+			continue
 		}
-
+		g := env.Topic + env.Key
+		if env.Group != "" {
+			g = "group:" + env.Group
+		}
+		groups[g] = append(groups[g], i)
 	}
 
-	t := p.topics[event]
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for _, idx := range groups {
+		wg.Add(1)
+		go func(idx []int) {
+			defer wg.Done()
+			for n, i := range idx {
+				err := p.deliver(ctx, envs[i])
+				if err == nil {
+					continue
+				}
+
+				mu.Lock()
+				errs[i] = err
+				if envs[i].Group != "" {
+					// keep group order intact, skip the rest of the chain
+					for _, j := range idx[n+1:] {
+						errs[j] = err
+					}
+				}
+				mu.Unlock()
+
+				if envs[i].Group != "" {
+					return
+				}
+			}
+		}(idx)
+	}
+	wg.Wait()
 
-	md := p.Config.getMetadata(event)
+	return errs
+}
 
-	if metadata != nil {
-		if err := mergo.Merge(&md, metadata); err != nil {
-			return err
-		}
+func (p *PubSub) deliver(ctx context.Context, env *Envelope) error {
+	if err := p.ec.checkChain(ctx, env); err != nil {
+		return err
 	}
 
-	mhash, err := hash(message)
-	if err != nil {
+	if err := p.transmit(ctx, env); err != nil {
 		return err
 	}
 
-	md["hash"] = mhash
+	p.ec.remember(ctx, env, p.Config.dedupeWindow())
 
-	seq := false
-
-	if key == "" {
-		key = mhash
-	} else {
-		seq = true
-		md["previous"] = p.ec.getPrevious(ctx, event+key)
-	}
+	return p.ec.advanceChain(ctx, env)
+}
 
-	msg := &EventMessage{
-		Data:     message,
-		Metadata: md,
+// transmit send encoded envelope to its topic, or to configured sender
+func (p *PubSub) transmit(ctx context.Context, env *Envelope) error {
+	if p.sender != nil {
+		return p.sender(ctx, env)
 	}
 
-	b, err := msg.ToBytes()
+	b, err := env.toBytes(ctx)
 	if err != nil {
 		return err
 	}
 
 	pmsg := &pubsub.Message{
-		Body: b,
-		Metadata: map[string]string{
-			"key": key,
-		},
-	}
-	if err := t.Send(ctx, pmsg); err != nil {
-		return err
-	}
-
-	if seq {
-		p.ec.setCurrent(ctx, event+key, mhash)
+		Body:     b,
+		Metadata: env.messageMetadata(),
 	}
+	return p.Config.Retry.send(ctx, p.topics.get, env.Topic, pmsg)
+}
 
+// Flush messages are sent synchronously, nothing to flush
+func (p *PubSub) Flush(ctx context.Context) error {
 	return nil
+}
 
+// Close stop accepting events, wait for publishes in progress, shutdown opened topics and close cache
+func (p *PubSub) Close(ctx context.Context) error {
+	p.mu.Lock()
+	if p.closed {
+		p.mu.Unlock()
+		return nil
+	}
+	p.closed = true
+	p.mu.Unlock()
+
+	// publishes past closed check still send with topics
+	published := make(chan struct{})
+	go func() {
+		p.publishing.Wait()
+		close(published)
+	}()
+
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-published:
+	}
+
+	rerr := p.topics.shutdown(ctx)
+	if err := p.ec.Close(); err != nil {
+		rerr = err
+	}
+	if err := p.Config.Encryption.closeKeeper(); err != nil {
+		rerr = err
+	}
+	if err := p.Config.ClaimCheck.closeBucket(); err != nil {
+		rerr = err
+	}
+	return rerr
 }
@@ -2,11 +2,15 @@ package event
 
 import (
 	"context"
+	"fmt"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/sahalazain/go-common/config"
 	_ "github.com/sahalazain/simplecache/mem"
 	"github.com/stretchr/testify/assert"
+	"gocloud.dev/pubsub"
 	_ "gocloud.dev/pubsub/mempubsub"
 )
 
@@ -36,3 +40,198 @@ func TestPubsub(t *testing.T) {
 	err = ps.Publish(ctx, "test", obj, nil)
 	assert.Nil(t, err)
 }
+
+func TestPubsubSender(t *testing.T) {
+	conf, err := config.Load(map[string]interface{}{"cache_url": "mem://psc"}, "")
+	assert.Nil(t, err)
+
+	ctx := context.Background()
+
+	_, err = NewPubSubEmitter(ctx, conf)
+	assert.NotNil(t, err)
+
+	sent := make([]*Envelope, 0)
+	ps, err := NewPubSubEmitter(ctx, conf, WithSender(func(ctx context.Context, env *Envelope) error {
+		sent = append(sent, env)
+		return nil
+	}))
+	assert.Nil(t, err)
+
+	assert.Nil(t, ps.Push(ctx, "sent", "P1", "picked", nil))
+	assert.Nil(t, ps.Push(ctx, "sent", "P1", "delivered", nil))
+
+	assert.Len(t, sent, 2)
+	assert.Equal(t, "picked", sent[0].Message)
+	assert.Equal(t, sent[0].Hash, sent[1].Metadata["previous"])
+	assert.Equal(t, int64(2), sent[1].Sequence)
+
+	assert.Nil(t, ps.Close(ctx))
+}
+
+func TestPubsubClose(t *testing.T) {
+	cfg := map[string]interface{}{
+		"cache_url":  "mem://pcc",
+		"pubsub_url": "mem://$TOPIC",
+	}
+	conf, err := config.Load(cfg, "")
+	assert.Nil(t, err)
+
+	ctx := context.Background()
+
+	ps, err := NewPubSubEmitter(ctx, conf)
+	assert.Nil(t, err)
+
+	err = ps.Publish(ctx, "pubsubclose", map[string]interface{}{"name": "SiCepat"}, nil)
+	assert.Nil(t, err)
+
+	assert.Nil(t, ps.Flush(ctx))
+	assert.Nil(t, ps.Close(ctx))
+	assert.Equal(t, ErrEmitterClosed, ps.Publish(ctx, "pubsubclose", map[string]interface{}{"name": "SiCepat"}, nil))
+}
+
+func TestPubsubCloseInFlight(t *testing.T) {
+	cfg := map[string]interface{}{
+		"cache_url":  "mem://pcf",
+		"pubsub_url": "mem://$TOPIC",
+	}
+	conf, err := config.Load(cfg, "")
+	assert.Nil(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err = pubsub.OpenTopic(ctx, "mem://inflight")
+	assert.Nil(t, err)
+
+	sub, err := pubsub.OpenSubscription(ctx, "mem://inflight")
+	assert.Nil(t, err)
+
+	started, release := make(chan struct{}), make(chan struct{})
+	hold := func(next SendFunc) SendFunc {
+		return func(ctx context.Context, env *Envelope) error {
+			close(started)
+			<-release
+			return next(ctx, env)
+		}
+	}
+	ps, err := NewPubSubEmitter(ctx, conf, WithInterceptor(hold))
+	assert.Nil(t, err)
+
+	published := make(chan error, 1)
+	go func() {
+		published <- ps.Publish(ctx, "inflight", "picked", nil)
+	}()
+	<-started
+
+	closed := make(chan error, 1)
+	go func() {
+		closed <- ps.Close(ctx)
+	}()
+
+	select {
+	case <-closed:
+		t.Fatal("closed before publish in flight finished")
+	case <-time.After(20 * time.Millisecond):
+	}
+	assert.Equal(t, ErrEmitterClosed, ps.Publish(ctx, "inflight", "delivered", nil))
+
+	// publish past closed check is still sent
+	close(release)
+	assert.Nil(t, <-published)
+	assert.Nil(t, <-closed)
+
+	m, err := sub.Receive(ctx)
+	assert.Nil(t, err)
+	m.Ack()
+}
+
+func newParallelPubsub(t testing.TB) *PubSub {
+	cfg := map[string]interface{}{
+		"cache_url":  "mem://ppc",
+		"pubsub_url": "mem://$TOPIC",
+		"config": map[string]interface{}{
+			"metadata": map[string]interface{}{
+				"default": map[string]interface{}{"version": 2},
+			},
+		},
+	}
+	conf, err := config.Load(cfg, "")
+	assert.Nil(t, err)
+
+	ps, err := NewPubSubEmitter(context.Background(), conf)
+	assert.Nil(t, err)
+	return ps
+}
+
+// run with -race to detect unsynchronized access
+func TestPubsubConcurrent(t *testing.T) {
+	ps := newParallelPubsub(t)
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				event := fmt.Sprintf("parallel%d", j%3)
+				obj := map[string]interface{}{"worker": i, "seq": j}
+				assert.Nil(t, ps.Publish(ctx, event, obj, map[string]interface{}{"worker": i}))
+				assert.Nil(t, ps.Push(ctx, event, fmt.Sprintf("key%d", i), obj, nil))
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	assert.Len(t, ps.topics.topics, 3)
+	assert.NotContains(t, ps.Config.Metadata["default"], "hash")
+	assert.Nil(t, ps.Close(ctx))
+}
+
+func BenchmarkPubsubParallel(b *testing.B) {
+	ps := newParallelPubsub(b)
+	ctx := context.Background()
+	obj := map[string]interface{}{
+		"name":    "SiCepat",
+		"address": "Jakarta",
+	}
+
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			if err := ps.Publish(ctx, "benchmark", obj, nil); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func TestPubsubChainConflict(t *testing.T) {
+	conf, err := config.Load(map[string]interface{}{"cache_url": "mem://pscc"}, "")
+	assert.Nil(t, err)
+
+	ctx := context.Background()
+
+	sent := 0
+	var ps *PubSub
+	// another process chains an event of the same key after this one was resolved
+	fork := func(next SendFunc) SendFunc {
+		return func(ctx context.Context, env *Envelope) error {
+			if env.Message == "forked" {
+				assert.Nil(t, ps.ec.advance(ctx, env.Topic+env.Key, env.previous, chainState{Hash: "other", Sequence: env.Sequence}))
+			}
+			return next(ctx, env)
+		}
+	}
+	ps, err = NewPubSubEmitter(ctx, conf, WithInterceptor(fork), WithSender(func(ctx context.Context, env *Envelope) error {
+		sent++
+		return nil
+	}))
+	assert.Nil(t, err)
+
+	assert.Nil(t, ps.Push(ctx, "sent", "P1", "picked", nil))
+	assert.Equal(t, ErrChainConflict, ps.Push(ctx, "sent", "P1", "forked", nil))
+	assert.Equal(t, 1, sent)
+
+	assert.Nil(t, ps.Close(ctx))
+}
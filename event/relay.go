@@ -0,0 +1,183 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/sahalazain/go-common/config"
+	"github.com/sahalazain/go-common/logger"
+	"go.opentelemetry.io/otel/trace"
+	"gocloud.dev/docstore"
+)
+
+const (
+	defaultPollInterval = 5 * time.Second
+	defaultBatchSize    = 100
+)
+
+// OutboxRelay publish records stored by outbox emitter to pubsub
+type OutboxRelay struct {
+	CollectionURL string        `json:"collection_url,omitempty" mapstructure:"collection_url"`
+	Config        EventConfig   `json:"config,omitempty" mapstructure:"config"`
+	PubsubURL     string        `json:"pubsub_url,omitempty" mapstructure:"pubsub_url"`
+	KafkaBroker   string        `json:"kafka_broker,omitempty" mapstructure:"kafka_broker"`
+	Kafka         KafkaConfig   `json:"kafka,omitempty" mapstructure:"kafka"`
+	PollInterval  time.Duration `json:"poll_interval,omitempty" mapstructure:"poll_interval"`
+	BatchSize     int           `json:"batch_size,omitempty" mapstructure:"batch_size"`
+	MarkSent      bool          `json:"mark_sent,omitempty" mapstructure:"mark_sent"`
+	collection    *docstore.Collection
+	topics        *topicCache
+}
+
+// NewOutboxRelay create outbox relay instance
+func NewOutboxRelay(ctx context.Context, conf config.Getter) (*OutboxRelay, error) {
+	var r OutboxRelay
+
+	if err := conf.Unmarshal(&r); err != nil {
+		return nil, err
+	}
+
+	if r.CollectionURL == "" {
+		return nil, errors.New("missing collection_url param")
+	}
+
+	if r.PubsubURL == "" {
+		return nil, errors.New("missing pubsub_url param")
+	}
+
+	if r.PollInterval <= 0 {
+		r.PollInterval = defaultPollInterval
+	}
+
+	if r.BatchSize <= 0 {
+		r.BatchSize = defaultBatchSize
+	}
+
+	kc, err := r.Kafka.saramaConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	col, err := docstore.OpenCollection(ctx, r.CollectionURL)
+	if err != nil {
+		return nil, err
+	}
+
+	r.collection = col
+	r.topics = newTopicCache(r.PubsubURL, r.KafkaBroker, kc)
+
+	return &r, nil
+}
+
+// Run relay pending records every poll interval until context is done
+func (r *OutboxRelay) Run(ctx context.Context) error {
+	log := logger.GetLoggerContext(ctx, "event", "outboxRelay")
+
+	ticker := time.NewTicker(r.PollInterval)
+	defer ticker.Stop()
+
+	for {
+		for {
+			n, err := r.Relay(ctx)
+			if err != nil {
+				log.WithError(err).Error("Error relaying outbox records")
+			}
+			// keep draining while batches are full
+			if err != nil || n < r.BatchSize {
+				break
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Relay publish one batch of pending records ordered by creation time, records of the same group are sent in order
+// and groups in parallel. Returns number of relayed records
+func (r *OutboxRelay) Relay(ctx context.Context) (int, error) {
+	records, err := r.pending(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	return sendGroups(ctx, records, r.publish)
+}
+
+// Send publish given records now regardless of their status, e.g. to retry failed records.
+// Records of the same group are sent in given order. Returns number of sent records
+func (r *OutboxRelay) Send(ctx context.Context, records []*OutboxRecord) (int, error) {
+	return sendGroups(ctx, records, r.publish)
+}
+
+// Close close relay topics and collection
+func (r *OutboxRelay) Close(ctx context.Context) error {
+	rerr := r.topics.shutdown(ctx)
+
+	if err := r.collection.Close(); err != nil {
+		rerr = err
+	}
+	return rerr
+}
+
+func (r *OutboxRelay) pending(ctx context.Context) ([]*OutboxRecord, error) {
+	q := r.collection.Query()
+	if r.MarkSent {
+		// ordered query must filter on its order field
+		q = q.Where("sent_at", "=", time.Time{}).Where("created_at", ">", time.Time{})
+	}
+
+	iter := q.OrderBy("created_at", docstore.Ascending).Limit(r.BatchSize).Get(ctx)
+	defer iter.Stop()
+
+	records := make([]*OutboxRecord, 0)
+	for {
+		var o OutboxRecord
+		err := iter.Next(ctx, &o)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		records = append(records, &o)
+	}
+
+	return records, nil
+}
+
+func (r *OutboxRelay) publish(ctx context.Context, o *OutboxRecord) error {
+	if err := sendRecord(ctx, &r.Config.Retry, r.topics.get, o); err != nil {
+		if ferr := markFailed(ctx, r.collection, o, err); ferr != nil {
+			logger.GetLoggerContext(ctx, "event", "outboxRelay").WithError(ferr).WithField("id", o.ID).Warn("Error recording failure")
+		}
+		return err
+	}
+
+	if r.MarkSent {
+		return markSent(ctx, r.collection, o)
+	}
+
+	return r.collection.Delete(ctx, o)
+}
+
+// sendRecord send stored record within producer span continuing trace of the original publish
+func sendRecord(ctx context.Context, retry *RetryPolicy, open topicOpener, o *OutboxRecord) error {
+	ctx, span := startSpan(extractContext(ctx, o.Headers), o.KafkaTopic, o.KafkaKey, trace.SpanKindProducer)
+	defer span.End()
+
+	msg, err := o.toMessage()
+	if err != nil {
+		endSpan(span, err)
+		return err
+	}
+	injectContext(ctx, msg.Metadata)
+
+	err = retry.send(ctx, open, o.KafkaTopic, msg)
+	endSpan(span, err)
+	return err
+}
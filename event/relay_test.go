@@ -0,0 +1,69 @@
+package event
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sahalazain/go-common/config"
+	_ "github.com/sahalazain/simplecache/mem"
+	"github.com/stretchr/testify/assert"
+	_ "gocloud.dev/docstore/memdocstore"
+	"gocloud.dev/pubsub"
+	_ "gocloud.dev/pubsub/mempubsub"
+)
+
+func TestOutboxRelay(t *testing.T) {
+	cfg := map[string]interface{}{
+		"collection_url": "mem://relay/_id",
+		"cache_url":      "mem://rc",
+		"pubsub_url":     "mem://$TOPIC",
+		"poll_interval":  "100ms",
+		"batch_size":     2,
+	}
+	conf, err := config.Load(cfg, "")
+	assert.Nil(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	out, err := NewOutboxEmitter(ctx, conf)
+	assert.Nil(t, err)
+
+	relay, err := NewOutboxRelay(ctx, conf)
+	assert.Nil(t, err)
+	assert.Equal(t, 100*time.Millisecond, relay.PollInterval)
+
+	topic, err := pubsub.OpenTopic(ctx, "mem://relayed")
+	assert.Nil(t, err)
+	assert.NotNil(t, topic)
+
+	sub, err := pubsub.OpenSubscription(ctx, "mem://relayed")
+	assert.Nil(t, err)
+
+	for _, name := range []string{"Jakarta", "Bandung", "Surabaya"} {
+		err = out.Push(ctx, "relayed", "parcel", map[string]interface{}{"city": name}, nil)
+		assert.Nil(t, err)
+	}
+
+	n, err := relay.Relay(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, n)
+
+	n, err = relay.Relay(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+
+	n, err = relay.Relay(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n)
+
+	for i := 0; i < 3; i++ {
+		m, err := sub.Receive(ctx)
+		assert.Nil(t, err)
+		assert.Equal(t, "parcel", m.Metadata["key"])
+		m.Ack()
+	}
+
+	assert.Nil(t, relay.Close(ctx))
+}
@@ -0,0 +1,278 @@
+package event
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"time"
+
+	"github.com/mitchellh/mapstructure"
+	"github.com/sahalazain/go-common/config"
+	"github.com/spf13/viper"
+	"gocloud.dev/docstore"
+)
+
+// replayedMetadataKey metadata entry marking republished event
+const replayedMetadataKey = "replayed"
+
+// replayDropped metadata entries recomputed by emitter when event is republished, idempotency key is dropped
+// so replayed event is not deduplicated against the original one
+var replayDropped = []string{"event", "hash", "previous", sequenceMetadataKey, idempotencyMetadataKey, encryptionMetadata, claimCheckMetadata}
+
+// Replay republish outbox records read from archive collection or JSONL export, filtered by topic, key and
+// creation time. Records with chain metadata are pushed with their key, others published
+type Replay struct {
+	ArchiveURL string `json:"archive_url,omitempty" mapstructure:"archive_url"`
+	// File JSONL export, one outbox record per line
+	File   string   `json:"file,omitempty" mapstructure:"file"`
+	Topics []string `json:"topics,omitempty" mapstructure:"topics"`
+	Key    string   `json:"key,omitempty" mapstructure:"key"`
+	// From inclusive and To exclusive bound of record creation time, RFC3339 in config
+	From time.Time `json:"from,omitempty" mapstructure:"from"`
+	To   time.Time `json:"to,omitempty" mapstructure:"to"`
+	// Rate maximum republished events per second, zero is unlimited
+	Rate float64 `json:"rate,omitempty" mapstructure:"rate"`
+	// DryRun only count matching records
+	DryRun bool `json:"dry_run,omitempty" mapstructure:"dry_run"`
+	// Config decode stored records, must have codecs, encryption and claim check of the emitter that stored them
+	Config  EventConfig `json:"config,omitempty" mapstructure:"config"`
+	archive *docstore.Collection
+}
+
+// NewReplay create replay instance
+func NewReplay(ctx context.Context, conf config.Getter) (*Replay, error) {
+	var r Replay
+
+	hook := viper.DecodeHook(mapstructure.ComposeDecodeHookFunc(
+		mapstructure.StringToTimeDurationHookFunc(),
+		mapstructure.StringToSliceHookFunc(","),
+		mapstructure.StringToTimeHookFunc(time.RFC3339),
+	))
+	if err := conf.Unmarshal(&r, hook); err != nil {
+		return nil, err
+	}
+
+	if r.ArchiveURL == "" && r.File == "" {
+		return nil, errors.New("missing archive_url or file param")
+	}
+
+	if r.ArchiveURL != "" && r.File != "" {
+		return nil, errors.New("[Replay] archive_url and file can not be used together")
+	}
+
+	if r.Rate < 0 {
+		return nil, errors.New("[Replay] rate must not be negative")
+	}
+
+	if err := r.Config.Encryption.openKeeper(ctx); err != nil {
+		return nil, err
+	}
+
+	if err := r.Config.ClaimCheck.openBucket(ctx); err != nil {
+		return nil, err
+	}
+
+	if r.ArchiveURL != "" {
+		col, err := docstore.OpenCollection(ctx, r.ArchiveURL)
+		if err != nil {
+			return nil, err
+		}
+		r.archive = col
+	}
+
+	return &r, nil
+}
+
+// Run republish matching records through emitter, returns number of republished records or,
+// on dry run, number of matching records. Emitter may be nil on dry run
+func (r *Replay) Run(ctx context.Context, em Emitter) (int, error) {
+	if !r.DryRun && em == nil {
+		return 0, errors.New("[Replay] missing emitter")
+	}
+
+	var tick <-chan time.Time
+	if r.Rate > 0 && !r.DryRun {
+		ticker := time.NewTicker(time.Duration(float64(time.Second) / r.Rate))
+		defer ticker.Stop()
+		tick = ticker.C
+	}
+
+	n := 0
+	err := r.records(ctx, func(o *OutboxRecord) error {
+		if !r.match(o) {
+			return nil
+		}
+
+		if r.DryRun {
+			n++
+			return nil
+		}
+
+		if tick != nil && n > 0 {
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-tick:
+			}
+		}
+
+		if err := r.republish(ctx, em, o); err != nil {
+			return err
+		}
+		n++
+		return nil
+	})
+
+	return n, err
+}
+
+// Close close archive collection
+func (r *Replay) Close() error {
+	var rerr error
+	if r.archive != nil {
+		rerr = r.archive.Close()
+	}
+	if err := r.Config.Encryption.closeKeeper(); err != nil {
+		rerr = err
+	}
+	if err := r.Config.ClaimCheck.closeBucket(); err != nil {
+		rerr = err
+	}
+	return rerr
+}
+
+// records iterate records of source in creation order of archive or line order of file
+func (r *Replay) records(ctx context.Context, fn func(o *OutboxRecord) error) error {
+	if r.archive == nil {
+		return r.fileRecords(ctx, fn)
+	}
+
+	// ordered query must filter on its order field
+	iter := r.archive.Query().Where("created_at", ">=", r.From).OrderBy("created_at", docstore.Ascending).Get(ctx)
+	defer iter.Stop()
+
+	for {
+		var o OutboxRecord
+		err := iter.Next(ctx, &o)
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+
+		if !r.To.IsZero() && !o.CreatedAt.Before(r.To) {
+			return nil
+		}
+
+		if err := fn(&o); err != nil {
+			return err
+		}
+	}
+}
+
+func (r *Replay) fileRecords(ctx context.Context, fn func(o *OutboxRecord) error) error {
+	f, err := os.Open(r.File)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	rd := bufio.NewReader(f)
+	for {
+		line, err := rd.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			var o OutboxRecord
+			if jerr := json.Unmarshal(line, &o); jerr != nil {
+				return jerr
+			}
+			if ferr := fn(&o); ferr != nil {
+				return ferr
+			}
+		}
+
+		if err == io.EOF {
+			return ctx.Err()
+		}
+	}
+}
+
+// match whether record is within replay filter
+func (r *Replay) match(o *OutboxRecord) bool {
+	if len(r.Topics) > 0 {
+		found := false
+		for _, t := range r.Topics {
+			if t == o.KafkaTopic {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if r.Key != "" && r.Key != o.KafkaKey {
+		return false
+	}
+
+	if o.CreatedAt.Before(r.From) {
+		return false
+	}
+
+	return r.To.IsZero() || o.CreatedAt.Before(r.To)
+}
+
+// republish decode record and send its event again marked as replayed
+func (r *Replay) republish(ctx context.Context, em Emitter, o *OutboxRecord) error {
+	msg, err := r.Config.decodeRecord(ctx, o)
+	if err != nil {
+		return err
+	}
+
+	md := make(map[string]interface{}, len(msg.Metadata)+1)
+	for k, v := range msg.Metadata {
+		md[k] = v
+	}
+	_, sequential := md["previous"]
+	for _, k := range replayDropped {
+		delete(md, k)
+	}
+	md[replayedMetadataKey] = true
+
+	if sequential {
+		return em.Push(ctx, o.KafkaTopic, o.KafkaKey, msg.Data, md)
+	}
+	return em.Publish(ctx, o.KafkaTopic, msg.Data, md)
+}
+
+// decodeRecord decode event message of outbox record, fetching claim checked payload and decrypting its data
+func (c *EventConfig) decodeRecord(ctx context.Context, o *OutboxRecord) (*EventMessage, error) {
+	body, err := o.value()
+	if err != nil {
+		return nil, err
+	}
+
+	if o.Headers[claimCheckHeader] != "" {
+		if body, err = c.ClaimCheck.fetch(ctx, body); err != nil {
+			return nil, err
+		}
+	}
+
+	codec, msg, err := c.decode(o.KafkaTopic, o.Headers, body)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := c.Encryption.decryptMessage(ctx, codec, msg); err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
@@ -0,0 +1,194 @@
+package event
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sahalazain/go-common/config"
+	_ "github.com/sahalazain/simplecache/mem"
+	"github.com/stretchr/testify/assert"
+	"gocloud.dev/docstore"
+	_ "gocloud.dev/docstore/memdocstore"
+)
+
+// testEmitter emitter recording sent events
+type testEmitter struct {
+	mu     sync.Mutex
+	events []Event
+	sent   []time.Time
+}
+
+func (e *testEmitter) Publish(ctx context.Context, event string, message interface{}, metadata map[string]interface{}) error {
+	return e.PublishBatch(ctx, []Event{{Name: event, Message: message, Metadata: metadata}})
+}
+
+func (e *testEmitter) Push(ctx context.Context, event, key string, message interface{}, metadata map[string]interface{}) error {
+	return e.PublishBatch(ctx, []Event{{Name: event, Key: key, Message: message, Metadata: metadata}})
+}
+
+func (e *testEmitter) PublishBatch(ctx context.Context, events []Event) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for range events {
+		e.sent = append(e.sent, time.Now())
+	}
+	e.events = append(e.events, events...)
+	return nil
+}
+
+func (e *testEmitter) Flush(ctx context.Context) error {
+	return nil
+}
+
+func (e *testEmitter) Close(ctx context.Context) error {
+	return nil
+}
+
+func TestReplayArchive(t *testing.T) {
+	cfg := map[string]interface{}{
+		"collection_url": "mem://replayarchive/_id",
+		"cache_url":      "mem://rpc",
+		"config": map[string]interface{}{
+			"compression": map[string]interface{}{"algorithm": "gzip"},
+			"encryption": map[string]interface{}{
+				"keeper_url": testKeeperURL,
+				"events":     []string{"parcel"},
+			},
+		},
+	}
+	conf, err := config.Load(cfg, "")
+	assert.Nil(t, err)
+
+	ctx := context.Background()
+
+	out, err := NewOutboxEmitter(ctx, conf)
+	assert.Nil(t, err)
+
+	from := time.Now()
+	assert.Nil(t, out.Push(ctx, "parcel", "P1", map[string]interface{}{"status": "picked"}, map[string]interface{}{"idempotency_key": "P1-picked"}))
+	assert.Nil(t, out.Push(ctx, "parcel", "P1", map[string]interface{}{"status": "delivered"}, nil))
+	assert.Nil(t, out.Publish(ctx, "manifest", map[string]interface{}{"manifest": "M1"}, nil))
+
+	rcfg := map[string]interface{}{
+		"archive_url": "mem://replayarchive/_id",
+		"topics":      "parcel",
+		"from":        from.Add(-time.Second).Format(time.RFC3339),
+		"dry_run":     true,
+		"config":      cfg["config"],
+	}
+	rconf, err := config.Load(rcfg, "")
+	assert.Nil(t, err)
+
+	replay, err := NewReplay(ctx, rconf)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"parcel"}, replay.Topics)
+	assert.False(t, replay.From.IsZero())
+
+	n, err := replay.Run(ctx, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, n)
+
+	replay.DryRun = false
+	em := &testEmitter{}
+	n, err = replay.Run(ctx, em)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, n)
+
+	assert.Len(t, em.events, 2)
+	statuses := map[interface{}]bool{}
+	for _, ev := range em.events {
+		assert.Equal(t, "parcel", ev.Name)
+		assert.Equal(t, "P1", ev.Key)
+		assert.Equal(t, true, ev.Metadata["replayed"])
+		for _, k := range []string{"hash", "previous", "sequence", "idempotency_key", "encryption"} {
+			assert.NotContains(t, ev.Metadata, k)
+		}
+		statuses[ev.Message.(map[string]interface{})["status"]] = true
+	}
+	assert.Equal(t, map[interface{}]bool{"picked": true, "delivered": true}, statuses)
+
+	assert.Nil(t, replay.Close())
+}
+
+func TestReplayFile(t *testing.T) {
+	cfg := map[string]interface{}{
+		"collection_url": "mem://replayexport/_id",
+		"cache_url":      "mem://rfc",
+	}
+	conf, err := config.Load(cfg, "")
+	assert.Nil(t, err)
+
+	ctx := context.Background()
+
+	out, err := NewOutboxEmitter(ctx, conf)
+	assert.Nil(t, err)
+
+	for _, city := range []string{"Jakarta", "Bandung", "Surabaya"} {
+		assert.Nil(t, out.Publish(ctx, "manifest", map[string]interface{}{"city": city}, nil))
+	}
+	assert.Nil(t, out.Push(ctx, "parcel", "P2", map[string]interface{}{"status": "picked"}, nil))
+
+	col, err := docstore.OpenCollection(ctx, "mem://replayexport/_id")
+	assert.Nil(t, err)
+
+	file := filepath.Join(t.TempDir(), "outbox.jsonl")
+	f, err := os.Create(file)
+	assert.Nil(t, err)
+
+	enc := json.NewEncoder(f)
+	iter := col.Query().Get(ctx)
+	for {
+		var o OutboxRecord
+		err := iter.Next(ctx, &o)
+		if err == io.EOF {
+			break
+		}
+		assert.Nil(t, err)
+		assert.Nil(t, enc.Encode(&o))
+	}
+	iter.Stop()
+	assert.Nil(t, f.Close())
+
+	rconf, err := config.Load(map[string]interface{}{
+		"file":   file,
+		"topics": []string{"manifest"},
+		"rate":   20,
+	}, "")
+	assert.Nil(t, err)
+
+	replay, err := NewReplay(ctx, rconf)
+	assert.Nil(t, err)
+
+	em := &testEmitter{}
+	n, err := replay.Run(ctx, em)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, n)
+	assert.Nil(t, replay.Close())
+
+	assert.Len(t, em.sent, 3)
+	// limited to one event every 50ms
+	assert.True(t, em.sent[2].Sub(em.sent[0]) >= 90*time.Millisecond)
+	for _, ev := range em.events {
+		assert.Equal(t, "", ev.Key)
+		assert.Equal(t, true, ev.Metadata["replayed"])
+	}
+
+	// time range excludes every record
+	replay.To = time.Now().Add(-time.Hour)
+	replay.Topics = nil
+	replay.DryRun = true
+	n, err = replay.Run(ctx, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n)
+
+	_, err = NewReplay(ctx, config.NewEmbedConfig(map[string]interface{}{}))
+	assert.NotNil(t, err)
+
+	assert.Nil(t, out.Close(ctx))
+}
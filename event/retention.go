@@ -0,0 +1,312 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/sahalazain/go-common/config"
+	"github.com/sahalazain/go-common/logger"
+	"gocloud.dev/docstore"
+)
+
+const (
+	statusSent   = "sent"
+	statusFailed = "failed"
+
+	defaultRetentionAge      = 7 * 24 * time.Hour
+	defaultRetentionInterval = time.Hour
+)
+
+// markSent keep delivered record marked as sent so it is purged later by retention
+func markSent(ctx context.Context, col *docstore.Collection, o *OutboxRecord) error {
+	return col.Update(ctx, o, docstore.Mods{
+		"sent_at": time.Now(),
+		"status":  statusSent,
+	})
+}
+
+// markFailed record delivery failure on record, failed record stays pending and is retried
+func markFailed(ctx context.Context, col *docstore.Collection, o *OutboxRecord, cause error) error {
+	return col.Update(ctx, o, docstore.Mods{
+		"status":     statusFailed,
+		"last_error": cause.Error(),
+		"failed_at":  time.Now(),
+		"attempts":   docstore.Increment(1),
+	})
+}
+
+// OutboxRetention purge delivered outbox records older than max age, moving them to archive collection
+// when configured, and report outbox size and age. Records marked as sent by relay or hybrid emitter
+// with mark_sent are purged by their send time, failed records are kept with their error
+type OutboxRetention struct {
+	CollectionURL string        `json:"collection_url,omitempty" mapstructure:"collection_url"`
+	ArchiveURL    string        `json:"archive_url,omitempty" mapstructure:"archive_url"`
+	MaxAge        time.Duration `json:"max_age,omitempty" mapstructure:"max_age"`
+	Interval      time.Duration `json:"interval,omitempty" mapstructure:"interval"`
+	BatchSize     int           `json:"batch_size,omitempty" mapstructure:"batch_size"`
+	// PurgeUnmarked purge records by creation time whether marked as sent or not, for outbox drained by Kafka Connect
+	// or by relay without mark_sent. Records still pending after max age are purged too, failed records are kept
+	PurgeUnmarked bool `json:"purge_unmarked,omitempty" mapstructure:"purge_unmarked"`
+	// RecordLayout layout of outbox records, default or debezium. Debezium records are never marked as sent
+	// and are purged only with purge_unmarked
+	RecordLayout string `json:"record_layout,omitempty" mapstructure:"record_layout"`
+	collection   *docstore.Collection
+	archive      *docstore.Collection
+}
+
+// OutboxReport outbox collection size and age
+type OutboxReport struct {
+	Total         int       `json:"total"`
+	Pending       int       `json:"pending"`
+	Sent          int       `json:"sent"`
+	Failed        int       `json:"failed"`
+	Bytes         int64     `json:"bytes"`
+	OldestPending time.Time `json:"oldest_pending,omitempty"`
+	OldestSent    time.Time `json:"oldest_sent,omitempty"`
+	// Topics number of pending records per topic
+	Topics map[string]int `json:"topics,omitempty"`
+}
+
+// NewOutboxRetention create outbox retention instance
+func NewOutboxRetention(ctx context.Context, conf config.Getter) (*OutboxRetention, error) {
+	var r OutboxRetention
+
+	if err := conf.Unmarshal(&r); err != nil {
+		return nil, err
+	}
+
+	if r.CollectionURL == "" {
+		return nil, errors.New("missing collection_url param")
+	}
+
+	if err := validLayout(r.RecordLayout); err != nil {
+		return nil, err
+	}
+
+	if r.RecordLayout == layoutDebezium && !r.PurgeUnmarked {
+		return nil, errors.New("[Retention] debezium records are never marked as sent, purge_unmarked is required")
+	}
+
+	if r.MaxAge <= 0 {
+		r.MaxAge = defaultRetentionAge
+	}
+
+	if r.Interval <= 0 {
+		r.Interval = defaultRetentionInterval
+	}
+
+	if r.BatchSize <= 0 {
+		r.BatchSize = defaultBatchSize
+	}
+
+	col, err := docstore.OpenCollection(ctx, r.CollectionURL)
+	if err != nil {
+		return nil, err
+	}
+	r.collection = col
+
+	if r.ArchiveURL != "" {
+		archive, err := docstore.OpenCollection(ctx, r.ArchiveURL)
+		if err != nil {
+			col.Close()
+			return nil, err
+		}
+		r.archive = archive
+	}
+
+	return &r, nil
+}
+
+// Run purge delivered records every interval until context is done
+func (r *OutboxRetention) Run(ctx context.Context) error {
+	log := logger.GetLoggerContext(ctx, "event", "outboxRetention")
+
+	ticker := time.NewTicker(r.Interval)
+	defer ticker.Stop()
+
+	for {
+		n, err := r.Purge(ctx)
+		if err != nil {
+			log.WithError(err).Error("Error purging outbox records")
+		} else if n > 0 {
+			log.WithField("count", n).Info("Outbox records purged")
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// Purge archive or delete records sent before max age, returns number of purged records
+func (r *OutboxRetention) Purge(ctx context.Context) (int, error) {
+	cutoff := time.Now().Add(-r.MaxAge)
+
+	purged := 0
+	for {
+		records, err := r.expired(ctx, cutoff)
+		if err != nil {
+			return purged, err
+		}
+
+		if len(records) == 0 {
+			return purged, nil
+		}
+
+		if r.archive != nil {
+			puts := r.archive.Actions()
+			for _, o := range records {
+				puts.Put(archived(o))
+			}
+			if err := puts.Do(ctx); err != nil {
+				return purged, err
+			}
+		}
+
+		deletes := r.collection.Actions()
+		for _, o := range records {
+			deletes.Delete(o)
+		}
+		if err := deletes.Do(ctx); err != nil {
+			return purged, err
+		}
+
+		purged += len(records)
+		if len(records) < r.BatchSize {
+			return purged, nil
+		}
+	}
+}
+
+// expired up to batch size of records to purge
+func (r *OutboxRetention) expired(ctx context.Context, cutoff time.Time) ([]outboxDocument, error) {
+	if r.PurgeUnmarked {
+		return r.expiredUnmarked(ctx, cutoff)
+	}
+
+	iter := r.collection.Query().
+		Where("status", "=", statusSent).
+		Where("sent_at", "<", cutoff).
+		Limit(r.BatchSize).
+		Get(ctx)
+	defer iter.Stop()
+
+	records := make([]outboxDocument, 0)
+	for {
+		var o OutboxRecord
+		err := iter.Next(ctx, &o)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		records = append(records, &o)
+	}
+
+	return records, nil
+}
+
+// expiredUnmarked records created before cutoff in configured layout. Failed records are skipped while reading
+// rather than limited away, so a batch full of them does not stop the purge
+func (r *OutboxRetention) expiredUnmarked(ctx context.Context, cutoff time.Time) ([]outboxDocument, error) {
+	field := docstore.FieldPath("created_at")
+	if r.RecordLayout == layoutDebezium {
+		field = "timestamp"
+	}
+
+	iter := r.collection.Query().Where(field, "<", cutoff).Get(ctx)
+	defer iter.Stop()
+
+	records := make([]outboxDocument, 0)
+	for len(records) < r.BatchSize {
+		var o outboxDocument = &OutboxRecord{}
+		if r.RecordLayout == layoutDebezium {
+			o = &DebeziumRecord{}
+		}
+
+		err := iter.Next(ctx, o)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		if rec, ok := o.(*OutboxRecord); ok && rec.Status == statusFailed {
+			continue
+		}
+		records = append(records, o)
+	}
+
+	return records, nil
+}
+
+// archived copy of record to put in archive, archived copy get its own revision while original revision
+// is needed to delete the record
+func archived(o outboxDocument) interface{} {
+	if rec, ok := o.(*OutboxRecord); ok {
+		a := *rec
+		a.Revision = nil
+		return &a
+	}
+	return o
+}
+
+// Report scan outbox collection and report number, size and age of its records
+func (r *OutboxRetention) Report(ctx context.Context) (*OutboxReport, error) {
+	return report(ctx, r.collection)
+}
+
+func report(ctx context.Context, col *docstore.Collection) (*OutboxReport, error) {
+	iter := col.Query().Get(ctx)
+	defer iter.Stop()
+
+	rep := &OutboxReport{Topics: make(map[string]int)}
+	for {
+		var o OutboxRecord
+		err := iter.Next(ctx, &o)
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		rep.Total++
+		rep.Bytes += int64(len(o.KafkaValue))
+
+		if !o.SentAt.IsZero() {
+			rep.Sent++
+			if rep.OldestSent.IsZero() || o.SentAt.Before(rep.OldestSent) {
+				rep.OldestSent = o.SentAt
+			}
+			continue
+		}
+
+		rep.Pending++
+		rep.Topics[o.KafkaTopic]++
+		if o.Status == statusFailed {
+			rep.Failed++
+		}
+		if rep.OldestPending.IsZero() || o.CreatedAt.Before(rep.OldestPending) {
+			rep.OldestPending = o.CreatedAt
+		}
+	}
+
+	return rep, nil
+}
+
+// Close close outbox and archive collections
+func (r *OutboxRetention) Close(ctx context.Context) error {
+	var rerr error
+	if r.archive != nil {
+		rerr = r.archive.Close()
+	}
+
+	if err := r.collection.Close(); err != nil {
+		rerr = err
+	}
+	return rerr
+}
@@ -0,0 +1,183 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sahalazain/go-common/config"
+	_ "github.com/sahalazain/simplecache/mem"
+	"github.com/stretchr/testify/assert"
+	"gocloud.dev/docstore"
+	_ "gocloud.dev/docstore/memdocstore"
+	_ "gocloud.dev/pubsub/mempubsub"
+)
+
+func TestOutboxRetention(t *testing.T) {
+	cfg := map[string]interface{}{
+		"collection_url": "mem://retained/_id",
+		"archive_url":    "mem://retainedarchive/_id",
+		"cache_url":      "mem://rtc",
+		"pubsub_url":     "mem://$TOPIC",
+		"mark_sent":      true,
+		"max_age":        "1h",
+	}
+	conf, err := config.Load(cfg, "")
+	assert.Nil(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	out, err := NewOutboxEmitter(ctx, conf)
+	assert.Nil(t, err)
+
+	relay, err := NewOutboxRelay(ctx, conf)
+	assert.Nil(t, err)
+
+	ret, err := NewOutboxRetention(ctx, conf)
+	assert.Nil(t, err)
+	assert.Equal(t, time.Hour, ret.MaxAge)
+
+	assert.Nil(t, out.Push(ctx, "retained", "P1", map[string]interface{}{"seq": 1}, nil))
+	assert.Nil(t, out.Push(ctx, "retained", "P1", map[string]interface{}{"seq": 2}, nil))
+	time.Sleep(time.Millisecond)
+	assert.Nil(t, out.Publish(ctx, "broken?invalid=1", map[string]interface{}{"seq": 3}, nil))
+
+	n, err := relay.Relay(ctx)
+	assert.NotNil(t, err)
+	assert.Equal(t, 2, n)
+
+	rep, err := ret.Report(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, rep.Total)
+	assert.Equal(t, 2, rep.Sent)
+	assert.Equal(t, 1, rep.Pending)
+	assert.Equal(t, 1, rep.Failed)
+	assert.True(t, rep.Bytes > 0)
+	assert.False(t, rep.OldestPending.IsZero())
+
+	n, err = ret.Purge(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n)
+
+	ret.MaxAge = time.Nanosecond
+	n, err = ret.Purge(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, n)
+
+	archive, err := docstore.OpenCollection(ctx, "mem://retainedarchive/_id")
+	assert.Nil(t, err)
+
+	iter := archive.Query().Get(ctx)
+	archived := 0
+	for {
+		var o OutboxRecord
+		if err := iter.Next(ctx, &o); err != nil {
+			break
+		}
+		assert.Equal(t, statusSent, o.Status)
+		archived++
+	}
+	iter.Stop()
+	assert.Equal(t, 2, archived)
+
+	col, err := docstore.OpenCollection(ctx, "mem://retained/_id")
+	assert.Nil(t, err)
+
+	iter = col.Query().Get(ctx)
+	var failed OutboxRecord
+	assert.Nil(t, iter.Next(ctx, &failed))
+	iter.Stop()
+	assert.Equal(t, statusFailed, failed.Status)
+	assert.Equal(t, 1, failed.Attempts)
+	assert.NotEmpty(t, failed.LastError)
+	assert.True(t, failed.SentAt.IsZero())
+}
+
+func TestOutboxRetentionUnmarked(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	cfg := map[string]interface{}{
+		"collection_url": "mem://unmarked/_id",
+		"cache_url":      "mem://rtu",
+		"purge_unmarked": true,
+		"batch_size":     1,
+	}
+	conf, err := config.Load(cfg, "")
+	assert.Nil(t, err)
+
+	out, err := NewOutboxEmitter(ctx, conf)
+	assert.Nil(t, err)
+	for i := 0; i < 3; i++ {
+		assert.Nil(t, out.Publish(ctx, "unmarked", map[string]interface{}{"seq": i}, nil))
+	}
+
+	col, err := docstore.OpenCollection(ctx, "mem://unmarked/_id")
+	assert.Nil(t, err)
+
+	// failed record is kept, even when a batch is full of failed records
+	var failed OutboxRecord
+	iter := col.Query().Get(ctx)
+	assert.Nil(t, iter.Next(ctx, &failed))
+	iter.Stop()
+	assert.Nil(t, markFailed(ctx, col, &failed, errors.New("broker unavailable")))
+
+	ret, err := NewOutboxRetention(ctx, conf)
+	assert.Nil(t, err)
+
+	n, err := ret.Purge(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, n)
+
+	ret.MaxAge = time.Nanosecond
+	n, err = ret.Purge(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, n)
+
+	rep, err := ret.Report(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, rep.Total)
+	assert.Equal(t, 1, rep.Failed)
+
+	// debezium records are purged by their timestamp
+	cfg = map[string]interface{}{
+		"collection_url": "mem://unmarkeddbz/_id",
+		"archive_url":    "mem://unmarkeddbzarchive/_id",
+		"cache_url":      "mem://rtud",
+		"record_layout":  "debezium",
+	}
+	conf, err = config.Load(cfg, "")
+	assert.Nil(t, err)
+
+	dbz, err := NewOutboxEmitter(ctx, conf)
+	assert.Nil(t, err)
+	for i := 0; i < 2; i++ {
+		assert.Nil(t, dbz.Publish(ctx, "unmarked", map[string]interface{}{"seq": i}, nil))
+	}
+
+	_, err = NewOutboxRetention(ctx, conf)
+	assert.NotNil(t, err)
+
+	cfg["purge_unmarked"] = true
+	conf, err = config.Load(cfg, "")
+	assert.Nil(t, err)
+
+	ret, err = NewOutboxRetention(ctx, conf)
+	assert.Nil(t, err)
+	ret.MaxAge = time.Nanosecond
+	n, err = ret.Purge(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, n)
+
+	archive, err := docstore.OpenCollection(ctx, "mem://unmarkeddbzarchive/_id")
+	assert.Nil(t, err)
+	iter = archive.Query().Get(ctx)
+	for i := 0; i < 2; i++ {
+		var d DebeziumRecord
+		assert.Nil(t, iter.Next(ctx, &d))
+		assert.Equal(t, "unmarked", d.AggregateType)
+	}
+	iter.Stop()
+}
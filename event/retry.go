@@ -0,0 +1,148 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"math"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	"gocloud.dev/gcerrors"
+	"gocloud.dev/pubsub"
+)
+
+const (
+	defaultInitialBackoff = 100 * time.Millisecond
+	defaultMaxBackoff     = 10 * time.Second
+	defaultMultiplier     = 2.0
+)
+
+var defaultRetryableCodes = []gcerrors.ErrorCode{
+	gcerrors.Unknown,
+	gcerrors.Internal,
+	gcerrors.ResourceExhausted,
+	gcerrors.DeadlineExceeded,
+}
+
+// RetryPolicy event sending retry policy
+type RetryPolicy struct {
+	MaxAttempts     int           `json:"max_attempts,omitempty" mapstructure:"max_attempts"`
+	InitialBackoff  time.Duration `json:"initial_backoff,omitempty" mapstructure:"initial_backoff"`
+	MaxBackoff      time.Duration `json:"max_backoff,omitempty" mapstructure:"max_backoff"`
+	Multiplier      float64       `json:"multiplier,omitempty" mapstructure:"multiplier"`
+	Jitter          float64       `json:"jitter,omitempty" mapstructure:"jitter"`
+	RetryableCodes  []string      `json:"retryable_codes,omitempty" mapstructure:"retryable_codes"`
+	DeadLetterTopic string        `json:"dead_letter_topic,omitempty" mapstructure:"dead_letter_topic"`
+}
+
+// send send message to topic with retry, message that runs out of attempts is sent to dead letter topic when configured
+func (r *RetryPolicy) send(ctx context.Context, open topicOpener, topic string, msg *pubsub.Message) error {
+	attempts, err := r.do(ctx, func() error {
+		t, err := open(ctx, topic)
+		if err != nil {
+			return err
+		}
+		return t.Send(ctx, msg)
+	})
+
+	if err == nil || r.DeadLetterTopic == "" || ctx.Err() != nil {
+		return err
+	}
+
+	md := make(map[string]string, len(msg.Metadata)+3)
+	for k, v := range msg.Metadata {
+		md[k] = v
+	}
+	md["dlq_topic"] = topic
+	md["dlq_reason"] = err.Error()
+	md["dlq_attempts"] = strconv.Itoa(attempts)
+
+	dlq, derr := open(ctx, strings.ReplaceAll(r.DeadLetterTopic, "$TOPIC", topic))
+	if derr != nil {
+		return err
+	}
+
+	if derr := dlq.Send(ctx, &pubsub.Message{Body: msg.Body, Metadata: md}); derr != nil {
+		return err
+	}
+
+	return nil
+}
+
+// do call fn until it succeed, returns non retryable error or attempts run out
+func (r *RetryPolicy) do(ctx context.Context, fn func() error) (int, error) {
+	max := r.MaxAttempts
+	if max <= 0 {
+		max = 1
+	}
+
+	var err error
+	for attempt := 1; ; attempt++ {
+		if err = fn(); err == nil {
+			return attempt, nil
+		}
+
+		if attempt >= max || !r.retryable(err) {
+			return attempt, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return attempt, err
+		case <-time.After(r.backoff(attempt)):
+		}
+	}
+}
+
+func (r *RetryPolicy) retryable(err error) bool {
+	if errors.Is(err, ErrEmitterClosed) {
+		return false
+	}
+
+	code := gcerrors.Code(err)
+	if len(r.RetryableCodes) == 0 {
+		for _, c := range defaultRetryableCodes {
+			if c == code {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, c := range r.RetryableCodes {
+		if strings.EqualFold(c, code.String()) {
+			return true
+		}
+	}
+	return false
+}
+
+func (r *RetryPolicy) backoff(attempt int) time.Duration {
+	initial := r.InitialBackoff
+	if initial <= 0 {
+		initial = defaultInitialBackoff
+	}
+
+	max := r.MaxBackoff
+	if max <= 0 {
+		max = defaultMaxBackoff
+	}
+
+	mul := r.Multiplier
+	if mul < 1 {
+		mul = defaultMultiplier
+	}
+
+	d := float64(initial) * math.Pow(mul, float64(attempt-1))
+	if d > float64(max) {
+		d = float64(max)
+	}
+
+	if r.Jitter > 0 {
+		d += d * r.Jitter * (rand.Float64()*2 - 1)
+	}
+
+	return time.Duration(d)
+}
@@ -0,0 +1,75 @@
+package event
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"gocloud.dev/pubsub"
+	"gocloud.dev/pubsub/mempubsub"
+)
+
+func TestRetryBackoff(t *testing.T) {
+	r := &RetryPolicy{
+		InitialBackoff: 10 * time.Millisecond,
+		MaxBackoff:     50 * time.Millisecond,
+	}
+
+	assert.Equal(t, 10*time.Millisecond, r.backoff(1))
+	assert.Equal(t, 20*time.Millisecond, r.backoff(2))
+	assert.Equal(t, 40*time.Millisecond, r.backoff(3))
+	assert.Equal(t, 50*time.Millisecond, r.backoff(4))
+
+	r.Jitter = 0.5
+	for i := 0; i < 10; i++ {
+		d := r.backoff(2)
+		assert.True(t, d >= 10*time.Millisecond && d <= 30*time.Millisecond)
+	}
+}
+
+func TestRetryDeadLetter(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	broken := mempubsub.NewTopic()
+	assert.Nil(t, broken.Shutdown(ctx))
+
+	dlq := mempubsub.NewTopic()
+	sub := mempubsub.NewSubscription(dlq, time.Minute)
+
+	opened := 0
+	open := func(ctx context.Context, name string) (*pubsub.Topic, error) {
+		if name == "orders.dlq" {
+			return dlq, nil
+		}
+		opened++
+		return broken, nil
+	}
+
+	r := &RetryPolicy{
+		MaxAttempts:     3,
+		InitialBackoff:  time.Millisecond,
+		RetryableCodes:  []string{"FailedPrecondition"},
+		DeadLetterTopic: "$TOPIC.dlq",
+	}
+
+	err := r.send(ctx, open, "orders", &pubsub.Message{Body: []byte("{}"), Metadata: map[string]string{"key": "k1"}})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, opened)
+
+	m, err := sub.Receive(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, "k1", m.Metadata["key"])
+	assert.Equal(t, "orders", m.Metadata["dlq_topic"])
+	assert.Equal(t, "3", m.Metadata["dlq_attempts"])
+	assert.NotEmpty(t, m.Metadata["dlq_reason"])
+	m.Ack()
+
+	r.RetryableCodes = nil
+	r.DeadLetterTopic = ""
+	opened = 0
+	err = r.send(ctx, open, "orders", &pubsub.Message{Body: []byte("{}")})
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, opened)
+}
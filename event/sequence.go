@@ -0,0 +1,336 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/sahalazain/go-common/config"
+	"github.com/sahalazain/go-common/logger"
+)
+
+const (
+	defaultSequenceWindow = 30 * time.Second
+	defaultMaxBuffered    = 100
+	defaultMaxKeys        = 10000
+)
+
+// ErrSequenceGap returned by sequencer handler when predecessor of event did not arrive within window,
+// so message is nacked and redelivered
+var ErrSequenceGap = errors.New("[Sequencer] previous event was not received")
+
+// Gap event whose predecessor did not arrive within window
+type Gap struct {
+	Event    string `json:"event,omitempty"`
+	Key      string `json:"key,omitempty"`
+	Expected string `json:"expected,omitempty"`
+	Previous string `json:"previous,omitempty"`
+	Hash     string `json:"hash,omitempty"`
+	Sequence int64  `json:"sequence,omitempty"`
+}
+
+// GapFunc called for every unresolved gap
+type GapFunc func(ctx context.Context, gap Gap)
+
+// Sequencer verify hash chain of events published with Push. Events of a key are handled in chain order,
+// out of order event waits up to window for its predecessor and duplicates are acked without being handled.
+// Waiting events release their subscriber handler slot, so their predecessors are received even with one handler.
+// Consumer may join in the middle of a chain, so first event of a key that is not chain start waits for window
+// and then starts the chain
+type Sequencer struct {
+	Window time.Duration `json:"window,omitempty" mapstructure:"window"`
+	// MaxBuffered maximum waiting events per key, further out of order events are treated as gap
+	MaxBuffered int `json:"max_buffered,omitempty" mapstructure:"max_buffered"`
+	// MaxKeys number of tracked keys above which idle keys are forgotten
+	MaxKeys int `json:"max_keys,omitempty" mapstructure:"max_keys"`
+	// SkipGaps handle event after unresolved gap instead of nacking it
+	SkipGaps bool `json:"skip_gaps,omitempty" mapstructure:"skip_gaps"`
+	// OnGap called for every unresolved gap
+	OnGap GapFunc `json:"-" mapstructure:"-"`
+	// OnDuplicate called instead of handler for duplicate, its error nacks the message
+	OnDuplicate Handler `json:"-" mapstructure:"-"`
+	mu          sync.Mutex
+	chains      map[string]*chain
+}
+
+// chain handling state of key
+type chain struct {
+	started  bool
+	last     string
+	sequence int64
+	busy     bool
+	waiting  int
+	changed  chan struct{}
+	seen     time.Time
+}
+
+// NewSequencer create sequencer instance, onGap may be nil
+func NewSequencer(conf config.Getter, onGap GapFunc) (*Sequencer, error) {
+	var s Sequencer
+
+	if conf != nil {
+		if err := conf.Unmarshal(&s); err != nil {
+			return nil, err
+		}
+	}
+
+	if s.Window <= 0 {
+		s.Window = defaultSequenceWindow
+	}
+
+	if s.MaxBuffered <= 0 {
+		s.MaxBuffered = defaultMaxBuffered
+	}
+
+	if s.MaxKeys <= 0 {
+		s.MaxKeys = defaultMaxKeys
+	}
+
+	s.OnGap = onGap
+	s.chains = make(map[string]*chain)
+
+	return &s, nil
+}
+
+// Handler wrap handler so events of the same key are handled in chain order.
+// Events without chain metadata are passed through
+func (s *Sequencer) Handler(next Handler) Handler {
+	return func(ctx context.Context, msg *EventMessage) error {
+		l, ok := linkOf(msg)
+		if !ok {
+			return next(ctx, msg)
+		}
+
+		slot := slotOf(ctx)
+		c, err := s.acquire(ctx, l, slot)
+		if err != nil {
+			return err
+		}
+
+		// slot released while waiting is taken back before handling
+		if err := slot.acquire(ctx); err != nil {
+			if c != nil {
+				s.release(c, l, false)
+			}
+			return err
+		}
+
+		if c == nil {
+			if s.OnDuplicate != nil {
+				return s.OnDuplicate(ctx, msg)
+			}
+			return nil
+		}
+
+		err = next(ctx, msg)
+		s.release(c, l, err == nil)
+		return err
+	}
+}
+
+// link chain metadata of event
+type link struct {
+	event    string
+	key      string
+	hash     string
+	previous string
+	sequence int64
+}
+
+func linkOf(msg *EventMessage) (link, bool) {
+	l := link{sequence: metadataInt(msg.Metadata[sequenceMetadataKey])}
+	l.event, _ = msg.Metadata["event"].(string)
+	l.key, _ = msg.Metadata["key"].(string)
+	l.hash, _ = msg.Metadata["hash"].(string)
+	previous, ok := msg.Metadata["previous"].(string)
+	l.previous = previous
+	return l, ok && l.key != "" && l.hash != ""
+}
+
+// acquire wait until event is next in chain of its key and mark chain busy, handler slot is released while waiting.
+// Returns nil chain for duplicate
+func (s *Sequencer) acquire(ctx context.Context, l link, slot *handlerSlot) (*chain, error) {
+	timer := time.NewTimer(s.Window)
+	defer timer.Stop()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c := s.chain(l.event + "/" + l.key)
+	waiting := false
+	defer func() {
+		if waiting {
+			c.waiting--
+		}
+	}()
+
+	expired := false
+	skip := false
+	for {
+		if c.duplicate(l) {
+			return nil, nil
+		}
+
+		if !c.busy && (c.next(l) || skip) {
+			c.busy = true
+			return c, nil
+		}
+
+		if !waiting {
+			if c.waiting >= s.MaxBuffered {
+				expired = true
+			} else {
+				waiting = true
+				c.waiting++
+			}
+		}
+
+		if expired && !skip {
+			// unknown predecessor of key seen for the first time is not a gap
+			if c.started {
+				if err := s.gap(ctx, c, l); err != nil {
+					return nil, err
+				}
+			}
+			// event is handled once chain is idle
+			skip = true
+			continue
+		}
+
+		changed := c.changed
+		s.mu.Unlock()
+		slot.release()
+
+		var timeout <-chan time.Time
+		if !expired {
+			timeout = timer.C
+		}
+
+		var err error
+		select {
+		case <-changed:
+		case <-timeout:
+			expired = true
+		case <-ctx.Done():
+			err = ctx.Err()
+		}
+
+		s.mu.Lock()
+		if err != nil {
+			return nil, err
+		}
+	}
+}
+
+// gap report unresolved gap of event, returns error unless gaps are skipped. Caller holds lock
+func (s *Sequencer) gap(ctx context.Context, c *chain, l link) error {
+	gap := Gap{Event: l.event, Key: l.key, Expected: c.last, Previous: l.previous, Hash: l.hash, Sequence: l.sequence}
+
+	logger.GetLoggerContext(ctx, "event", "sequencer").WithField("key", gap.Key).WithField("event", gap.Event).
+		WithField("expected", gap.Expected).WithField("previous", gap.Previous).Warn("Sequence gap")
+
+	if s.OnGap != nil {
+		s.OnGap(ctx, gap)
+	}
+
+	if s.SkipGaps {
+		return nil
+	}
+	return ErrSequenceGap
+}
+
+// release mark chain idle, advancing it when event was handled
+func (s *Sequencer) release(c *chain, l link, handled bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	c.busy = false
+	if handled {
+		c.started = true
+		c.last = l.hash
+		if l.sequence > c.sequence {
+			c.sequence = l.sequence
+		}
+	}
+	c.seen = time.Now()
+
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+// chain get or create chain state, forgetting idle keys when there are too many. Caller holds lock
+func (s *Sequencer) chain(k string) *chain {
+	if c, ok := s.chains[k]; ok {
+		return c
+	}
+
+	if len(s.chains) >= s.MaxKeys {
+		s.forget()
+	}
+
+	c := &chain{changed: make(chan struct{}), seen: time.Now()}
+	s.chains[k] = c
+	return c
+}
+
+// forget drop idle keys not seen within window
+func (s *Sequencer) forget() {
+	cutoff := time.Now().Add(-s.Window)
+	for k, c := range s.chains {
+		if !c.busy && c.waiting == 0 && c.seen.Before(cutoff) {
+			delete(s.chains, k)
+		}
+	}
+}
+
+// next whether event follows the last handled event. Events with equal payload share hash, so sequence decides
+// when both have one
+func (c *chain) next(l link) bool {
+	if c.started && l.sequence > 0 && c.sequence > 0 {
+		return l.sequence == c.sequence+1
+	}
+	return l.previous == c.last
+}
+
+// duplicate whether event was already handled, by sequence when event has one and by hash otherwise
+func (c *chain) duplicate(l link) bool {
+	if !c.started {
+		return false
+	}
+	if l.sequence > 0 {
+		return l.sequence <= c.sequence
+	}
+	return l.hash == c.last
+}
+
+// metadataInt numeric metadata as int64, decoded type depends on codec
+func metadataInt(v interface{}) int64 {
+	switch n := v.(type) {
+	case int:
+		return int64(n)
+	case int8:
+		return int64(n)
+	case int16:
+		return int64(n)
+	case int32:
+		return int64(n)
+	case int64:
+		return n
+	case uint:
+		return int64(n)
+	case uint8:
+		return int64(n)
+	case uint16:
+		return int64(n)
+	case uint32:
+		return int64(n)
+	case uint64:
+		return int64(n)
+	case float32:
+		return int64(n)
+	case float64:
+		return int64(n)
+	}
+	return 0
+}
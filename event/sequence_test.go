@@ -0,0 +1,242 @@
+package event
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/sahalazain/go-common/config"
+	_ "github.com/sahalazain/simplecache/mem"
+	"github.com/stretchr/testify/assert"
+	"gocloud.dev/pubsub"
+	_ "gocloud.dev/pubsub/mempubsub"
+)
+
+// testChain chain of n events of key, numbered from first
+func testChain(key string, first, n int) []*EventMessage {
+	msgs := make([]*EventMessage, n)
+	for i := range msgs {
+		seq := first + i
+		previous := ""
+		if seq > 1 {
+			previous = fmt.Sprintf("h%d", seq-1)
+		}
+		msgs[i] = &EventMessage{
+			Data: seq,
+			Metadata: map[string]interface{}{
+				"event":    "parcel",
+				"key":      key,
+				"hash":     fmt.Sprintf("h%d", seq),
+				"previous": previous,
+				"sequence": float64(seq),
+			},
+		}
+	}
+	return msgs
+}
+
+func testSequencer(t *testing.T, cfg map[string]interface{}, onGap GapFunc) *Sequencer {
+	conf, err := config.Load(cfg, "")
+	assert.Nil(t, err)
+
+	s, err := NewSequencer(conf, onGap)
+	assert.Nil(t, err)
+	return s
+}
+
+func TestSequencerReorder(t *testing.T) {
+	s := testSequencer(t, map[string]interface{}{"window": "5s"}, nil)
+
+	var mu sync.Mutex
+	handled := make([]int, 0)
+	h := s.Handler(func(ctx context.Context, msg *EventMessage) error {
+		mu.Lock()
+		handled = append(handled, msg.Data.(int))
+		mu.Unlock()
+		return nil
+	})
+
+	ctx := context.Background()
+	msgs := testChain("P1", 1, 5)
+
+	var wg sync.WaitGroup
+	for i := len(msgs) - 1; i >= 0; i-- {
+		wg.Add(1)
+		go func(m *EventMessage) {
+			defer wg.Done()
+			assert.Nil(t, h(ctx, m))
+		}(msgs[i])
+		time.Sleep(5 * time.Millisecond)
+	}
+	wg.Wait()
+
+	assert.Equal(t, []int{1, 2, 3, 4, 5}, handled)
+
+	// redelivered events are dropped
+	dup := 0
+	s.OnDuplicate = func(ctx context.Context, msg *EventMessage) error {
+		dup++
+		return nil
+	}
+	assert.Nil(t, h(ctx, msgs[4]))
+	assert.Nil(t, h(ctx, msgs[1]))
+	assert.Equal(t, 2, dup)
+	assert.Len(t, handled, 5)
+
+	// events without chain are passed through
+	assert.Nil(t, h(ctx, &EventMessage{Data: 0, Metadata: map[string]interface{}{"event": "parcel"}}))
+	assert.Len(t, handled, 6)
+}
+
+func TestSequencerEqualPayload(t *testing.T) {
+	conf, err := config.Load(map[string]interface{}{"cache_url": "mem://sqe"}, "")
+	assert.Nil(t, err)
+
+	ctx := context.Background()
+
+	// chain as pushed by emitter, events with equal payload have equal hash
+	msgs := make([]*EventMessage, 0)
+	ps, err := NewPubSubEmitter(ctx, conf, WithSender(func(ctx context.Context, env *Envelope) error {
+		md := copyMetadata(env.Metadata)
+		md["key"] = env.Key
+		msgs = append(msgs, &EventMessage{Data: env.Message, Metadata: md})
+		return nil
+	}))
+	assert.Nil(t, err)
+	for _, status := range []string{"at_hub", "out", "at_hub", "at_hub"} {
+		assert.Nil(t, ps.Push(ctx, "parcel", "P5", status, nil))
+	}
+	assert.Nil(t, ps.Close(ctx))
+	assert.Equal(t, msgs[0].Metadata["hash"], msgs[3].Metadata["hash"])
+
+	s := testSequencer(t, map[string]interface{}{"window": "5s"}, nil)
+
+	var mu sync.Mutex
+	handled := make([]int64, 0)
+	h := s.Handler(func(ctx context.Context, msg *EventMessage) error {
+		mu.Lock()
+		handled = append(handled, metadataInt(msg.Metadata[sequenceMetadataKey]))
+		mu.Unlock()
+		return nil
+	})
+
+	var wg sync.WaitGroup
+	for i := len(msgs) - 1; i >= 0; i-- {
+		wg.Add(1)
+		go func(m *EventMessage) {
+			defer wg.Done()
+			assert.Nil(t, h(ctx, m))
+		}(msgs[i])
+		time.Sleep(5 * time.Millisecond)
+	}
+	wg.Wait()
+
+	assert.Equal(t, []int64{1, 2, 3, 4}, handled)
+
+	// redelivered event with the same hash as the last one is still a duplicate
+	assert.Nil(t, h(ctx, msgs[0]))
+	assert.Len(t, handled, 4)
+}
+
+func TestSequencerGap(t *testing.T) {
+	gaps := make([]Gap, 0)
+	s := testSequencer(t, map[string]interface{}{"window": "50ms"}, func(ctx context.Context, gap Gap) {
+		gaps = append(gaps, gap)
+	})
+
+	handled := make([]int, 0)
+	h := s.Handler(func(ctx context.Context, msg *EventMessage) error {
+		handled = append(handled, msg.Data.(int))
+		return nil
+	})
+
+	ctx := context.Background()
+	msgs := testChain("P2", 1, 3)
+
+	assert.Nil(t, h(ctx, msgs[0]))
+	assert.Equal(t, ErrSequenceGap, h(ctx, msgs[2]))
+	assert.Equal(t, []int{1}, handled)
+	assert.Equal(t, []Gap{{Event: "parcel", Key: "P2", Expected: "h1", Previous: "h2", Hash: "h3", Sequence: 3}}, gaps)
+
+	s.SkipGaps = true
+	assert.Nil(t, h(ctx, msgs[2]))
+	assert.Equal(t, []int{1, 3}, handled)
+	assert.Len(t, gaps, 2)
+}
+
+func TestSequencerJoin(t *testing.T) {
+	gaps := 0
+	s := testSequencer(t, map[string]interface{}{"window": "50ms"}, func(ctx context.Context, gap Gap) {
+		gaps++
+	})
+
+	handled := make([]int, 0)
+	h := s.Handler(func(ctx context.Context, msg *EventMessage) error {
+		handled = append(handled, msg.Data.(int))
+		return nil
+	})
+
+	ctx := context.Background()
+	msgs := testChain("P3", 7, 2)
+
+	// consumer joined in the middle of chain
+	start := time.Now()
+	assert.Nil(t, h(ctx, msgs[0]))
+	assert.True(t, time.Since(start) >= 50*time.Millisecond)
+	assert.Nil(t, h(ctx, msgs[1]))
+
+	assert.Equal(t, []int{7, 8}, handled)
+	assert.Equal(t, 0, gaps)
+}
+
+func TestSubscriberSequencer(t *testing.T) {
+	cfg := map[string]interface{}{
+		"cache_url":  "mem://sqc",
+		"pubsub_url": "mem://$TOPIC",
+	}
+	conf, err := config.Load(cfg, "")
+	assert.Nil(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err = pubsub.OpenTopic(ctx, "mem://sequenced")
+	assert.Nil(t, err)
+
+	ps, err := NewPubSubEmitter(ctx, conf)
+	assert.Nil(t, err)
+
+	sub, err := NewSubscriber(ctx, conf)
+	assert.Nil(t, err)
+
+	s, err := NewSequencer(nil, nil)
+	assert.Nil(t, err)
+
+	received := make(chan int, 10)
+	err = sub.Subscribe(ctx, "sequenced", s.Handler(func(ctx context.Context, msg *EventMessage) error {
+		var n int
+		if err := msg.Decode(&n); err != nil {
+			return err
+		}
+		received <- n
+		return nil
+	}))
+	assert.Nil(t, err)
+
+	for i := 1; i <= 10; i++ {
+		assert.Nil(t, ps.Push(ctx, "sequenced", "P4", i, nil))
+	}
+
+	for i := 1; i <= 10; i++ {
+		select {
+		case n := <-received:
+			assert.Equal(t, i, n)
+		case <-ctx.Done():
+			t.Fatal("event not received")
+		}
+	}
+
+	assert.Nil(t, sub.Close(ctx))
+}
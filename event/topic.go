@@ -0,0 +1,128 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/Shopify/sarama"
+	"gocloud.dev/pubsub"
+	"gocloud.dev/pubsub/kafkapubsub"
+)
+
+type topicOpener func(ctx context.Context, topic string) (*pubsub.Topic, error)
+
+type topicEntry struct {
+	ready chan struct{}
+	topic *pubsub.Topic
+	err   error
+}
+
+// topicCache open each topic lazily and only once, safe for concurrent use
+type topicCache struct {
+	open   topicOpener
+	mu     sync.Mutex
+	topics map[string]*topicEntry
+	closed bool
+}
+
+func newTopicCache(pubsubURL, kafkaBroker string, kc *sarama.Config) *topicCache {
+	return &topicCache{
+		open: func(ctx context.Context, topic string) (*pubsub.Topic, error) {
+			return openTopic(ctx, pubsubURL, kafkaBroker, kc, topic)
+		},
+		topics: make(map[string]*topicEntry),
+	}
+}
+
+// get return opened topic, goroutines asking for a topic being opened wait for the first one
+func (c *topicCache) get(ctx context.Context, name string) (*pubsub.Topic, error) {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil, ErrEmitterClosed
+	}
+
+	e, ok := c.topics[name]
+	if ok {
+		c.mu.Unlock()
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-e.ready:
+			return e.topic, e.err
+		}
+	}
+
+	e = &topicEntry{ready: make(chan struct{})}
+	c.topics[name] = e
+	c.mu.Unlock()
+
+	e.topic, e.err = c.open(ctx, name)
+	if e.err != nil {
+		// allow next caller to retry opening
+		c.mu.Lock()
+		delete(c.topics, name)
+		c.mu.Unlock()
+	}
+	close(e.ready)
+
+	return e.topic, e.err
+}
+
+// shutdown shutdown all opened topics, further get will fail
+func (c *topicCache) shutdown(ctx context.Context) error {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.closed = true
+	topics := c.topics
+	c.topics = make(map[string]*topicEntry)
+	c.mu.Unlock()
+
+	var rerr error
+	for _, e := range topics {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-e.ready:
+		}
+
+		if e.err != nil {
+			continue
+		}
+
+		if err := e.topic.Shutdown(ctx); err != nil {
+			rerr = err
+		}
+	}
+	return rerr
+}
+
+func openTopic(ctx context.Context, pubsubURL, kafkaBroker string, kc *sarama.Config, topic string) (*pubsub.Topic, error) {
+	to := strings.ReplaceAll(pubsubURL, "$TOPIC", topic)
+
+	u, err := url.Parse(to)
+	if err != nil {
+		return nil, err
+	}
+
+	if u.Scheme != "kafka" {
+		return pubsub.OpenTopic(ctx, to)
+	}
+
+	brokers := kafkaBroker
+	if strings.Contains(u.Host, ":") {
+		brokers = u.Host
+	}
+
+	if brokers == "" {
+		return nil, errors.New("missing kafka broker")
+	}
+
+	return kafkapubsub.OpenTopic(strings.Split(brokers, ","), kc, topic, &kafkapubsub.TopicOptions{KeyName: kafkaKeyName})
+}
@@ -0,0 +1,56 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gocloud.dev/pubsub"
+	"gocloud.dev/pubsub/mempubsub"
+)
+
+func TestTopicCacheSingleOpen(t *testing.T) {
+	ctx := context.Background()
+
+	var opened int64
+	fail := true
+	tc := &topicCache{
+		open: func(ctx context.Context, name string) (*pubsub.Topic, error) {
+			atomic.AddInt64(&opened, 1)
+			if name == "broken" && fail {
+				return nil, errors.New("broker unavailable")
+			}
+			return mempubsub.NewTopic(), nil
+		},
+		topics: make(map[string]*topicEntry),
+	}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			topic, err := tc.get(ctx, "shared")
+			assert.Nil(t, err)
+			assert.NotNil(t, topic)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int64(1), atomic.LoadInt64(&opened))
+
+	_, err := tc.get(ctx, "broken")
+	assert.NotNil(t, err)
+
+	fail = false
+	topic, err := tc.get(ctx, "broken")
+	assert.Nil(t, err)
+	assert.NotNil(t, topic)
+	assert.Equal(t, int64(3), atomic.LoadInt64(&opened))
+
+	assert.Nil(t, tc.shutdown(ctx))
+	_, err = tc.get(ctx, "shared")
+	assert.Equal(t, ErrEmitterClosed, err)
+}
@@ -0,0 +1,67 @@
+package event
+
+import (
+	"context"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const tracerName = "github.com/sahalazain/go-common/event"
+
+var propagator = propagation.NewCompositeTextMapPropagator(propagation.TraceContext{}, propagation.Baggage{})
+
+// tracing start producer span for each delivered event and inject its context into event metadata and headers
+func tracing(next SendFunc) SendFunc {
+	return func(ctx context.Context, env *Envelope) error {
+		ctx, span := startSpan(ctx, env.Topic, env.Key, trace.SpanKindProducer)
+		defer span.End()
+
+		carrier := propagation.MapCarrier{}
+		propagator.Inject(ctx, carrier)
+		for k, v := range carrier {
+			env.Metadata[k] = v
+			env.setHeader(k, v)
+		}
+
+		err := next(ctx, env)
+		endSpan(span, err)
+		return err
+	}
+}
+
+func startSpan(ctx context.Context, topic, key string, kind trace.SpanKind) (context.Context, trace.Span) {
+	name := topic + " send"
+	if kind == trace.SpanKindConsumer {
+		name = topic + " receive"
+	}
+
+	return otel.Tracer(tracerName).Start(ctx, name,
+		trace.WithSpanKind(kind),
+		trace.WithAttributes(
+			attribute.String("messaging.destination", topic),
+			attribute.String("messaging.destination_kind", "topic"),
+			attribute.String("messaging.message_key", key),
+		),
+	)
+}
+
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+}
+
+// extractContext extract trace context from message headers
+func extractContext(ctx context.Context, headers map[string]string) context.Context {
+	return propagator.Extract(ctx, propagation.MapCarrier(headers))
+}
+
+// injectContext inject trace context of ctx into message headers
+func injectContext(ctx context.Context, headers map[string]string) {
+	propagator.Inject(ctx, propagation.MapCarrier(headers))
+}
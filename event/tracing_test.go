@@ -0,0 +1,89 @@
+package event
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/sahalazain/go-common/config"
+	_ "github.com/sahalazain/simplecache/mem"
+	"github.com/stretchr/testify/assert"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"go.opentelemetry.io/otel/trace"
+	_ "gocloud.dev/docstore/memdocstore"
+	"gocloud.dev/pubsub"
+	_ "gocloud.dev/pubsub/mempubsub"
+)
+
+func TestTracePropagation(t *testing.T) {
+	exporter := tracetest.NewInMemoryExporter()
+	tp := sdktrace.NewTracerProvider(sdktrace.WithSyncer(exporter))
+	otel.SetTracerProvider(tp)
+	defer otel.SetTracerProvider(trace.NewNoopTracerProvider())
+
+	cfg := map[string]interface{}{
+		"collection_url": "mem://traced/_id",
+		"cache_url":      "mem://tc",
+		"pubsub_url":     "mem://$TOPIC",
+	}
+	conf, err := config.Load(cfg, "")
+	assert.Nil(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	_, err = pubsub.OpenTopic(ctx, "mem://traced")
+	assert.Nil(t, err)
+
+	cons, err := NewConsumer(ctx, conf)
+	assert.Nil(t, err)
+
+	received := make(chan trace.SpanContext, 2)
+	err = cons.Subscribe(ctx, "traced", func(ctx context.Context, msg *EventMessage) error {
+		assert.NotEmpty(t, msg.Metadata["traceparent"])
+		received <- trace.SpanContextFromContext(ctx)
+		return nil
+	})
+	assert.Nil(t, err)
+
+	ps, err := NewPubSubEmitter(ctx, conf)
+	assert.Nil(t, err)
+
+	out, err := NewOutboxEmitter(ctx, conf)
+	assert.Nil(t, err)
+
+	relay, err := NewOutboxRelay(ctx, conf)
+	assert.Nil(t, err)
+
+	pctx, parent := otel.Tracer("test").Start(ctx, "request")
+	assert.Nil(t, ps.Publish(pctx, "traced", map[string]interface{}{"via": "pubsub"}, nil))
+	assert.Nil(t, out.Publish(pctx, "traced", map[string]interface{}{"via": "outbox"}, nil))
+	parent.End()
+
+	n, err := relay.Relay(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, n)
+
+	for i := 0; i < 2; i++ {
+		select {
+		case sc := <-received:
+			assert.Equal(t, parent.SpanContext().TraceID(), sc.TraceID())
+		case <-ctx.Done():
+			t.Fatal("event not received")
+		}
+	}
+	assert.Nil(t, cons.Close(ctx))
+
+	producers := 0
+	for _, s := range exporter.GetSpans() {
+		assert.Equal(t, parent.SpanContext().TraceID(), s.SpanContext.TraceID())
+		if s.SpanKind == trace.SpanKindProducer {
+			assert.Equal(t, "traced send", s.Name)
+			producers++
+		}
+	}
+	// pubsub publish, outbox publish and relay send
+	assert.Equal(t, 3, producers)
+}
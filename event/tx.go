@@ -0,0 +1,149 @@
+package event
+
+import (
+	"context"
+	"time"
+
+	"go.mongodb.org/mongo-driver/mongo"
+	"gocloud.dev/gcerrors"
+)
+
+// Tx collect events emitted by unit of work, they are stored together with its domain changes
+type Tx struct {
+	events []Event
+}
+
+// Publish emit event within transaction
+func (t *Tx) Publish(event string, message interface{}, metadata map[string]interface{}) {
+	t.events = append(t.events, Event{Name: event, Message: message, Metadata: metadata})
+}
+
+// Push emit sequential event within transaction
+func (t *Tx) Push(event, key string, message interface{}, metadata map[string]interface{}) {
+	t.events = append(t.events, Event{Name: event, Key: key, Message: message, Metadata: metadata})
+}
+
+// PublishBatch emit events within transaction
+func (t *Tx) PublishBatch(events []Event) {
+	t.events = append(t.events, events...)
+}
+
+// UnitOfWork apply domain document changes and emit events through tx.
+// Every write must use given ctx so it joins the transaction, unit of work may be run again when transaction is retried
+type UnitOfWork func(ctx context.Context, tx *Tx) error
+
+// Transaction run unit of work and store its outbox records in one mongo transaction of session,
+// such as session of storage.CachedCollection.GetMongoSession. Caller owns session and ends it.
+// Without session, e.g. on memdocstore or with transaction disabled, domain changes are applied first
+// and outbox records are stored afterwards in order on best effort basis
+func (o *Outbox) Transaction(ctx context.Context, session mongo.Session, work UnitOfWork) error {
+	o.mu.RLock()
+	closed := o.closed
+	o.mu.RUnlock()
+
+	if closed {
+		return ErrEmitterClosed
+	}
+
+	var created []*Envelope
+	unlock := func() {}
+	defer func() { unlock() }()
+
+	if session == nil {
+		var err error
+		if created, unlock, err = o.runWork(ctx, work); err != nil {
+			return err
+		}
+	} else {
+		_, err := session.WithTransaction(ctx, func(sc mongo.SessionContext) (interface{}, error) {
+			// chain keys of aborted attempt are released before unit of work is retried
+			unlock()
+			var err error
+			created, unlock, err = o.runWork(sc, work)
+			return nil, err
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// emitter cache is outside transaction, chain is advanced once records are committed
+	var rerr error
+	for _, env := range created {
+		o.ec.remember(ctx, env, o.Config.dedupeWindow())
+		if err := o.ec.advanceChain(ctx, env); err != nil && rerr == nil {
+			rerr = err
+		}
+	}
+	return rerr
+}
+
+// runWork run unit of work and store its events, returns envelopes of newly created records
+// and unlock of their chain keys
+func (o *Outbox) runWork(ctx context.Context, work UnitOfWork) ([]*Envelope, func(), error) {
+	tx := &Tx{}
+	if err := work(ctx, tx); err != nil {
+		return nil, func() {}, err
+	}
+
+	if len(tx.events) == 0 {
+		return nil, func() {}, nil
+	}
+
+	var created []*Envelope
+	envs, errs, unlock := o.Config.resolve(ctx, o.ec, tx.events)
+	intercept(ctx, o.interceptors, envs, errs, func(ctx context.Context, envs []*Envelope) BatchError {
+		stored, serrs := o.storeOrdered(ctx, envs)
+		created = append(created, stored...)
+		return serrs
+	})
+
+	if len(errs) > 0 {
+		return nil, unlock, errs
+	}
+	return created, unlock, nil
+}
+
+// storeOrdered store records one by one in event order, records already stored are skipped.
+// Writes stop at the first failure since a failed write aborts the transaction
+func (o *Outbox) storeOrdered(ctx context.Context, envs []*Envelope) ([]*Envelope, BatchError) {
+	errs := make(BatchError)
+	records, idx := toRecords(ctx, envs, errs)
+	created := make([]*Envelope, 0, len(records))
+
+	docs, err := o.documents(envs, records, idx)
+	if err != nil {
+		for _, i := range idx {
+			errs[i] = err
+		}
+		return created, errs
+	}
+
+	var ferr error
+	for j, rec := range docs {
+		i := idx[j]
+		if ferr != nil {
+			errs[i] = ferr
+			continue
+		}
+
+		err := o.collection.Get(ctx, rec)
+		if err == nil {
+			continue
+		}
+
+		if gcerrors.Code(err) == gcerrors.NotFound {
+			rec.setCreatedAt(time.Now())
+			err = o.collection.Create(ctx, rec)
+		}
+
+		if err != nil {
+			ferr = err
+			errs[i] = err
+			continue
+		}
+		created = append(created, envs[i])
+	}
+
+	return created, errs
+}
@@ -0,0 +1,85 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/sahalazain/go-common/config"
+	_ "github.com/sahalazain/simplecache/mem"
+	"github.com/stretchr/testify/assert"
+	"gocloud.dev/docstore"
+	_ "gocloud.dev/docstore/memdocstore"
+)
+
+type testOrder struct {
+	ID     string `docstore:"id"`
+	Status string `docstore:"status"`
+}
+
+func TestOutboxTransaction(t *testing.T) {
+	cfg := map[string]interface{}{
+		"collection_url": "mem://txoutbox/_id",
+		"cache_url":      "mem://txc",
+	}
+	conf, err := config.Load(cfg, "")
+	assert.Nil(t, err)
+
+	ctx := context.Background()
+
+	out, err := NewOutboxEmitter(ctx, conf)
+	assert.Nil(t, err)
+
+	orders, err := docstore.OpenCollection(ctx, "mem://txorders/id")
+	assert.Nil(t, err)
+	defer orders.Close()
+
+	err = out.Transaction(ctx, nil, func(ctx context.Context, tx *Tx) error {
+		order := &testOrder{ID: "O1", Status: "created"}
+		if err := orders.Create(ctx, order); err != nil {
+			return err
+		}
+		tx.Push("order", order.ID, order, nil)
+		tx.Publish("audit", map[string]interface{}{"order": order.ID}, nil)
+		return nil
+	})
+	assert.Nil(t, err)
+
+	assert.Nil(t, orders.Get(ctx, &testOrder{ID: "O1"}))
+	assert.Equal(t, 2, countRecords(t, "mem://txoutbox/_id"))
+
+	mhash, err := hash(&testOrder{ID: "O1", Status: "created"})
+	assert.Nil(t, err)
+	assert.Equal(t, mhash, out.ec.getPrevious(ctx, "orderO1"))
+
+	err = out.Transaction(ctx, nil, func(ctx context.Context, tx *Tx) error {
+		tx.Push("order", "O1", &testOrder{ID: "O1", Status: "paid"}, nil)
+		return errors.New("payment rejected")
+	})
+	assert.NotNil(t, err)
+	assert.Equal(t, 2, countRecords(t, "mem://txoutbox/_id"))
+	assert.Equal(t, mhash, out.ec.getPrevious(ctx, "orderO1"))
+
+	assert.Nil(t, out.Close(ctx))
+	err = out.Transaction(ctx, nil, func(ctx context.Context, tx *Tx) error { return nil })
+	assert.Equal(t, ErrEmitterClosed, err)
+}
+
+func countRecords(t *testing.T, url string) int {
+	ctx := context.Background()
+
+	col, err := docstore.OpenCollection(ctx, url)
+	assert.Nil(t, err)
+
+	iter := col.Query().Get(ctx)
+	defer iter.Stop()
+
+	n := 0
+	for {
+		var o OutboxRecord
+		if err := iter.Next(ctx, &o); err != nil {
+			return n
+		}
+		n++
+	}
+}
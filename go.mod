@@ -3,15 +3,26 @@ module github.com/sahalazain/go-common
 go 1.16
 
 require (
+	github.com/Shopify/sarama v1.27.2
+	github.com/golang/snappy v0.0.2
 	github.com/hgfischer/go-otp v1.0.0
 	github.com/imdario/mergo v0.3.12
 	github.com/jbenet/go-base58 v0.0.0-20150317085156-6237cf65f3a6
+	github.com/klauspost/compress v1.11.3
+	github.com/linkedin/goavro/v2 v2.10.1
 	github.com/mitchellh/mapstructure v1.4.1
 	github.com/sahalazain/simplecache v0.0.0-20210309025651-15ea970633b3
 	github.com/sirupsen/logrus v1.8.1
 	github.com/spf13/viper v1.7.1
-	github.com/stretchr/testify v1.7.0
+	github.com/stretchr/testify v1.7.1
+	github.com/vmihailenco/msgpack/v5 v5.3.5
+	github.com/xdg-go/scram v1.0.2
 	go.mongodb.org/mongo-driver v1.5.1
+	go.opentelemetry.io/otel v1.7.0
+	go.opentelemetry.io/otel/sdk v1.7.0
+	go.opentelemetry.io/otel/trace v1.7.0
 	gocloud.dev v0.22.0
+	gocloud.dev/docstore/mongodocstore v0.22.0
 	gocloud.dev/pubsub/kafkapubsub v0.22.0
+	google.golang.org/protobuf v1.25.0
 )